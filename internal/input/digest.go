@@ -1 +1,153 @@
 package input
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// DefinitionDigest calculates the digest of a marshalled LLB definition.
+func DefinitionDigest(dt []byte) godigest.Digest {
+	return godigest.SHA512.FromBytes(dt)
+}
+
+// ContentDigest calculates the digest of content alone, without following
+// any references.
+func ContentDigest(content []byte) godigest.Digest {
+	return godigest.SHA512.FromBytes(content)
+}
+
+// RemoteContextDigest calculates the digest of a context the build fetches
+// itself. ref has to be pinned, e.g. to a commit, to be meaningful.
+func RemoteContextDigest(ref string) godigest.Digest {
+	return godigest.SHA512.FromString(ref)
+}
+
+// SourceDigest calculates the digest of all files src serves, excluding
+// what its excludes exclude. Paths, modes, file contents and symlink targets
+// are included, same as with DirDigest.
+func SourceDigest(ctx context.Context, src buildctl.SyncedSource) (godigest.Digest, error) {
+	fsys := src.FS(&fsutil.WalkOpt{
+		ExcludePatterns: src.Excludes,
+	})
+	d := godigest.SHA512.Digester()
+	err := fsys.Walk(ctx, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := fi.Sys().(*fstypes.Stat)
+		if !ok {
+			return fmt.Errorf("%s has no stat info", p)
+		}
+		fmt.Fprintf(d.Hash(), "%s\x00%o\x00", filepath.ToSlash(p), fi.Mode())
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			fmt.Fprintf(d.Hash(), "%s\x00", st.Linkname)
+		case fi.Mode().IsRegular():
+			f, err := fsys.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintf(d.Hash(), "%d\x00", fi.Size())
+			if _, err := io.Copy(d.Hash(), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return d.Digest(), nil
+}
+
+// DirDigest calculates the digest of all files below dir. Paths, modes,
+// file contents and symlink targets are included.
+func DirDigest(dir string) (godigest.Digest, error) {
+	d := godigest.SHA512.Digester()
+	err := filepath.WalkDir(dir, func(p string, e fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(d.Hash(), "%s\x00%o\x00", filepath.ToSlash(rel), fi.Mode())
+
+		switch {
+		case fi.Mode()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(d.Hash(), "%s\x00", target)
+		case fi.Mode().IsRegular():
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			fmt.Fprintf(d.Hash(), "%d\x00", fi.Size())
+			if _, err := io.Copy(d.Hash(), f); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return d.Digest(), nil
+}
+
+// Combine calculates a single digest from the digests of several inputs,
+// identified by name.
+func Combine(digests map[string]godigest.Digest) godigest.Digest {
+	names := make([]string, 0, len(digests))
+	for name := range digests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	d := godigest.SHA512.Digester()
+	for _, name := range names {
+		fmt.Fprintf(d.Hash(), "%s\x00%s\x00", name, digests[name])
+	}
+	return d.Digest()
+}
+
+// OCILayoutDigest calculates the digest of the index of the OCI layout at
+// dir. Blobs are content addressed, so the index covers all of them.
+func OCILayoutDigest(dir string) (godigest.Digest, error) {
+	dt, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return "", err
+	}
+	return ContentDigest(dt), nil
+}
+
+// FileDigest calculates the digest of the content of filename.
+func FileDigest(filename string) (godigest.Digest, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return godigest.SHA512.FromReader(f)
+}
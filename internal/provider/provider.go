@@ -5,7 +5,9 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/abergmeier/terraform-provider-buildkit/internal/resources"
 	"github.com/abergmeier/terraform-provider-buildkit/pkg/kubectl"
@@ -25,6 +27,11 @@ import (
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 )
 
+const (
+	// Time cancelled builds get to close their sessions after a signal
+	stopGracePeriod = 10 * time.Second
+)
+
 var (
 	defaultConfigFlags = genericclioptions.NewConfigFlags(true).WithDeprecatedPasswordFlag().WithDiscoveryBurst(300).WithDiscoveryQPS(50.0)
 	stderr             = os.Stderr
@@ -75,6 +82,11 @@ var (
 				Description: "Special tooling for accessing Kubernetes",
 				Optional:    true,
 			},
+			"registries": {
+				Attributes:          tfsdk.MapNestedAttributes(registryAttributes),
+				MarkdownDescription: "Registries the provider talks to for resolving and pushing, keyed by host, e.g. `docker.io` or `localhost:5000`. Pushes to `http` or `insecure` registries are marked `registry.insecure`. Pulls within builds are configured in buildkitd.toml",
+				Optional:            true,
+			},
 		},
 	}
 )
@@ -107,6 +119,7 @@ type arguments struct {
 	Kubernetes    *struct {
 		PortForwards []portForward `tfsdk:"port_forwards"`
 	} `tfsdk:"kubernetes"`
+	Registries map[string]registryArguments `tfsdk:"registries"`
 }
 
 func (p *provider) GetSchema(context.Context) (tfsdk.Schema, diag.Diagnostics) {
@@ -138,18 +151,46 @@ func (p *provider) Configure(ctx context.Context, req tprovider.ConfigureRequest
 		}(horriblerangebehaviorofgo)
 	}
 
+	rc, diags := resolveRegistry(args.Registries)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
 	c, err := resolveClient(&args)
 	if err != nil {
 		resp.Diagnostics.AddError("Buildkit Client creation failed", err.Error())
 		return
 	}
+	// Running builds are cancelled on signals, so that their sessions are
+	// closed cleanly before the client is closed
+	stopped := make(chan struct{})
+	builds := &sync.WaitGroup{}
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
 	go func() {
 		defer c.Close()
 		_ = <-sigs
+		close(stopped)
+
+		cancelled := make(chan struct{})
+		go func() {
+			builds.Wait()
+			close(cancelled)
+		}()
+		// A second signal or builds not reacting do not wait any longer
+		select {
+		case <-cancelled:
+		case <-sigs:
+		case <-time.After(stopGracePeriod):
+		}
 	}()
-	resp.ResourceData = c
+	resp.ResourceData = &resources.ProviderData{
+		Client:   c,
+		Registry: rc,
+		Stopped:  stopped,
+		Builds:   builds,
+	}
 }
 
 func (p *provider) DataSources(context.Context) []func() datasource.DataSource {
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	registryAttributes = map[string]tfsdk.Attribute{
+		"mirrors": {
+			Type: types.ListType{
+				ElemType: types.StringType,
+			},
+			Description: "Hosts to try pulls on first, in order. Mirrors are reached with the http, insecure and ca_files settings of this registry, unless configured as registries themselves",
+			Optional:    true,
+		},
+		"http": {
+			Type:        types.BoolType,
+			Description: "Talk plain HTTP instead of HTTPS",
+			Optional:    true,
+		},
+		"insecure": {
+			Type:        types.BoolType,
+			Description: "Skip verifying the certificate of the registry",
+			Optional:    true,
+		},
+		"ca_files": {
+			Type: types.ListType{
+				ElemType: types.StringType,
+			},
+			Description: "Files of PEM encoded CA certificates to trust in addition to the system ones",
+			Optional:    true,
+		},
+	}
+)
+
+type registryArguments struct {
+	Mirrors  []string `tfsdk:"mirrors"`
+	HTTP     *bool    `tfsdk:"http"`
+	Insecure *bool    `tfsdk:"insecure"`
+	CAFiles  []string `tfsdk:"ca_files"`
+}
+
+// resolveRegistry creates the registry client configured by registries.
+func resolveRegistry(registries map[string]registryArguments) (*registry.Client, diag.Diagnostics) {
+	hosts := make(registry.Hosts, len(registries))
+	for host, r := range registries {
+		hosts[host] = registry.HostConfig{
+			Mirrors:   r.Mirrors,
+			PlainHTTP: r.HTTP != nil && *r.HTTP,
+			Insecure:  r.Insecure != nil && *r.Insecure,
+			RootCAs:   r.CAFiles,
+		}
+	}
+	rc, err := registry.NewClientWithHosts(hosts)
+	if err != nil {
+		return nil, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("registries"), "Configuring registries failed", err.Error()),
+		}
+	}
+	return rc, nil
+}
@@ -0,0 +1,321 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/frontend/dockerfile/shell"
+)
+
+const (
+	// Base of stages starting from an empty filesystem
+	baseImageScratch = "scratch"
+)
+
+// planBaseImages resolves the base images of the Dockerfile of plan to
+// digests, so that moved tags show up as a change. Images failing to resolve
+// keep the digest of state, if any.
+func planBaseImages(ctx context.Context, rc *registry.Client, state tfsdk.State, plan *tfsdk.Plan) diag.Diagnostics {
+	prior := types.Map{ElemType: types.StringType, Null: true}
+	if !state.Raw.IsNull() {
+		diags := state.GetAttribute(ctx, path.Root("base_images"), &prior)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	images, diags := knownBaseImages(ctx, rc, *plan, prior)
+	if diags.HasError() {
+		return diags
+	}
+	diags.Append(plan.SetAttribute(ctx, path.Root("base_images"), images)...)
+	return diags
+}
+
+// knownBaseImages resolves the base images of the Dockerfile of plan. The
+// result is unknown in case the Dockerfile is not known yet and null in case
+// it is not available locally at all.
+func knownBaseImages(ctx context.Context, rc *registry.Client, plan tfsdk.Plan, prior types.Map) (types.Map, diag.Diagnostics) {
+	unknown := types.Map{ElemType: types.StringType, Unknown: true}
+	null := types.Map{ElemType: types.StringType, Null: true}
+
+	frontend := types.String{}
+	diags := plan.GetAttribute(ctx, path.Root("frontend"), &frontend)
+	if diags.HasError() || frontend.IsUnknown() {
+		return unknown, diags
+	}
+	if frontend.Value != frontendDockerfile {
+		return null, nil
+	}
+
+	opts, known, diags := knownStringMap(ctx, plan, path.Root("opts"))
+	if diags.HasError() || !known {
+		return unknown, diags
+	}
+	attrs := make(map[string]string, len(opts))
+	for k, v := range opts {
+		attrs[k] = v
+	}
+	for _, name := range []string{"build_args", "sensitive_build_args"} {
+		buildArgs, known, diags := knownStringMap(ctx, plan, path.Root(name))
+		if diags.HasError() || !known {
+			return unknown, diags
+		}
+		for k, v := range buildArgs {
+			attrs[frontendAttrBuildArgPrefix+k] = v
+		}
+	}
+	contexts, diags := knownNamedContexts(ctx, plan)
+	if diags.HasError() || contexts == nil {
+		return unknown, diags
+	}
+	for name := range contexts {
+		attrs[frontendAttrNamedContextPrefix+name] = ""
+	}
+
+	dt, _, ok, diags := planDockerfile(ctx, plan, opts)
+	if diags.HasError() {
+		return unknown, diags
+	}
+	if !ok {
+		local, known, diags := planLocalDockerfile(ctx, plan)
+		if diags.HasError() || !known || local {
+			return unknown, diags
+		}
+		return null, nil
+	}
+	return baseImages(ctx, rc, dt, attrs, prior)
+}
+
+// planLocalDockerfile checks whether the Dockerfile of plan is served by
+// this provider, as opposed to being part of a remote context.
+func planLocalDockerfile(ctx context.Context, plan tfsdk.Plan) (local bool, known bool, diags diag.Diagnostics) {
+	inline := types.String{}
+	diags = plan.GetAttribute(ctx, path.Root("dockerfile_inline"), &inline)
+	if diags.HasError() || inline.IsUnknown() {
+		return false, false, diags
+	}
+	localDirs, known, diags := knownStringMap(ctx, plan, path.Root("local_dirs"))
+	if diags.HasError() || !known {
+		return false, false, diags
+	}
+	remote, diags := planRemoteContext(ctx, plan)
+	if diags.HasError() || remote.IsUnknown() {
+		return false, false, diags
+	}
+	_, dir := localDirs[localNameDockerfile]
+	return remote.IsNull() || dir || !inline.IsNull(), true, nil
+}
+
+// resolveBaseImages resolves the base images during apply, in case they
+// could not be resolved during planning. Images failing to resolve again are
+// left unpinned.
+func (args *builtArguments) resolveBaseImages(ctx context.Context, rc *registry.Client, frontendAttrs map[string]string) diag.Diagnostics {
+	if args.BaseImages.IsNull() {
+		return nil
+	}
+	if !args.BaseImages.IsUnknown() {
+		diags := diag.Diagnostics{}
+		for ref, e := range args.BaseImages.Elems {
+			if !e.IsUnknown() {
+				continue
+			}
+			dgst, err := resolveBaseImage(ctx, rc, ref)
+			if err != nil {
+				diags.AddAttributeWarning(path.Root("base_images").AtMapKey(ref), fmt.Sprintf("Resolving base image %s failed", ref), err.Error())
+				args.BaseImages.Elems[ref] = types.String{Null: true}
+				continue
+			}
+			args.BaseImages.Elems[ref] = types.String{Value: dgst}
+		}
+		return diags
+	}
+
+	args.BaseImages = types.Map{ElemType: types.StringType, Null: true}
+	if args.LLBDefinition != nil || args.Frontend == nil || *args.Frontend != frontendDockerfile {
+		return nil
+	}
+	_, dt, ok := localDockerfile(args)
+	if !ok {
+		return nil
+	}
+	images, diags := baseImages(ctx, rc, dt, frontendAttrs, types.Map{ElemType: types.StringType, Null: true})
+	if diags.HasError() {
+		return diags
+	}
+	// Failures were reported by baseImages already
+	for ref, e := range images.Elems {
+		if e.IsUnknown() {
+			images.Elems[ref] = types.String{Null: true}
+		}
+	}
+	args.BaseImages = images
+	return diags
+}
+
+// pinBaseImages replaces the base images of the build by the digests they
+// resolved to, using named contexts. Named contexts set explicitly take
+// precedence.
+func (args *builtArguments) pinBaseImages(frontendAttrs map[string]string) diag.Diagnostics {
+	if args.PinBaseImages == nil || !*args.PinBaseImages || args.BaseImages.IsNull() || args.BaseImages.IsUnknown() {
+		return nil
+	}
+	for ref, e := range args.BaseImages.Elems {
+		dgst, ok := e.(types.String)
+		if !ok || dgst.IsNull() || dgst.IsUnknown() {
+			continue
+		}
+		name, ok := baseImageContext(ref)
+		if !ok {
+			continue
+		}
+		if _, ok := frontendAttrs[frontendAttrNamedContextPrefix+name]; ok {
+			continue
+		}
+		named, err := reference.ParseNormalizedNamed(ref)
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("base_images").AtMapKey(ref), "Invalid base image", err.Error()),
+			}
+		}
+		frontendAttrs[frontendAttrNamedContextPrefix+name] = fmt.Sprintf("docker-image://%s@%s", reference.TrimNamed(named), dgst.Value)
+	}
+	return nil
+}
+
+// baseImages resolves the images the stages of the Dockerfile dt are based on
+// to digests, keyed by reference as written after expanding build args.
+// Images replaced by named contexts in frontendAttrs are skipped. Images
+// failing to resolve keep their digest in prior, if any, and are unknown
+// otherwise, since the build may still be able to pull them.
+func baseImages(ctx context.Context, rc *registry.Client, dt []byte, frontendAttrs map[string]string, prior types.Map) (types.Map, diag.Diagnostics) {
+	null := types.Map{ElemType: types.StringType, Null: true}
+	if syntaxDirective.Match(dt) {
+		return null, nil
+	}
+	refs, err := baseImageRefs(dt, frontendAttrs)
+	if err != nil {
+		// Invalid Dockerfiles are reported by checkDockerfile
+		return null, nil
+	}
+
+	diags := diag.Diagnostics{}
+	images := types.Map{ElemType: types.StringType, Elems: map[string]attr.Value{}}
+	for _, ref := range refs {
+		name, ok := baseImageContext(ref)
+		if !ok {
+			continue
+		}
+		if _, ok := frontendAttrs[frontendAttrNamedContextPrefix+name]; ok {
+			continue
+		}
+
+		if _, err := registry.ParseReference(ref); err != nil {
+			continue
+		}
+		dgst, err := resolveBaseImage(ctx, rc, ref)
+		if err == nil {
+			images.Elems[ref] = types.String{Value: dgst}
+			continue
+		}
+		if p, ok := prior.Elems[ref].(types.String); ok && !p.IsNull() && !p.IsUnknown() {
+			diags.AddAttributeWarning(path.Root("base_images").AtMapKey(ref), fmt.Sprintf("Resolving base image %s failed", ref), fmt.Sprintf("Keeping the digest %s it resolved to before: %s", p.Value, err))
+			images.Elems[ref] = p
+			continue
+		}
+		diags.AddAttributeWarning(path.Root("base_images").AtMapKey(ref), fmt.Sprintf("Resolving base image %s failed", ref), fmt.Sprintf("Resolving again when applying: %s", err))
+		images.Elems[ref] = types.String{Unknown: true}
+	}
+	return images, diags
+}
+
+// resolveBaseImage resolves ref to a digest, unless it is pinned already.
+func resolveBaseImage(ctx context.Context, rc *registry.Client, ref string) (string, error) {
+	r, err := registry.ParseReference(ref)
+	if err != nil {
+		return "", err
+	}
+	if r.Digest != "" {
+		return r.Digest.String(), nil
+	}
+	desc, err := rc.ResolveMirrored(ctx, r.String())
+	if err != nil {
+		return "", err
+	}
+	return desc.Digest.String(), nil
+}
+
+// baseImageRefs lists the image references of the FROM instructions of the
+// Dockerfile dt, expanded the same way the dockerfile frontend does. Stages
+// based on other stages or scratch are skipped.
+func baseImageRefs(dt []byte, frontendAttrs map[string]string) ([]string, error) {
+	ast, err := parser.Parse(bytes.NewReader(dt))
+	if err != nil {
+		return nil, err
+	}
+	stages, metaArgs, err := instructions.Parse(ast.AST)
+	if err != nil {
+		return nil, err
+	}
+
+	lex := shell.NewLex(ast.EscapeToken)
+	env := map[string]string{}
+	for _, a := range metaArgs {
+		for _, kv := range a.Args {
+			if v, ok := frontendAttrs[frontendAttrBuildArgPrefix+kv.Key]; ok {
+				env[kv.Key] = v
+				continue
+			}
+			if kv.Value == nil {
+				continue
+			}
+			v, err := lex.ProcessWordWithMap(*kv.Value, env)
+			if err != nil {
+				return nil, err
+			}
+			env[kv.Key] = v
+		}
+	}
+
+	seen := map[string]struct{}{}
+	stageNames := map[string]struct{}{}
+	refs := []string{}
+	for _, s := range stages {
+		ref, err := lex.ProcessWordWithMap(s.BaseName, env)
+		if err != nil {
+			return nil, err
+		}
+		_, stage := stageNames[strings.ToLower(ref)]
+		if s.Name != "" {
+			stageNames[s.Name] = struct{}{}
+		}
+		if _, ok := seen[ref]; ok || stage || ref == "" || strings.EqualFold(ref, baseImageScratch) {
+			continue
+		}
+		seen[ref] = struct{}{}
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs, nil
+}
+
+// baseImageContext is the name of the named context the dockerfile frontend
+// looks up to replace the base image ref.
+func baseImageContext(ref string) (string, bool) {
+	named, err := reference.ParseNormalizedNamed(ref)
+	if err != nil {
+		return "", false
+	}
+	return strings.TrimSuffix(reference.FamiliarString(named), ":latest"), true
+}
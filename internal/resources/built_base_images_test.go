@@ -0,0 +1,64 @@
+package resources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestBaseImagesResolveFailure(t *testing.T) {
+	ctx := context.Background()
+	_, host, rc := newTestRegistry(t)
+	desc, err := rc.PushManifest(ctx, host+"/test/base:1", ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := host + "/test/base:1"
+	missing := host + "/test/missing:1"
+	dt := []byte("FROM " + base + "\nFROM " + missing + "\n")
+
+	prior := types.Map{
+		ElemType: types.StringType,
+		Elems: map[string]attr.Value{
+			missing: types.String{Value: "sha256:0000000000000000000000000000000000000000000000000000000000000000"},
+		},
+	}
+	images, diags := baseImages(ctx, rc, dt, map[string]string{}, prior)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if got := images.Elems[base]; !got.Equal(types.String{Value: desc.Digest.String()}) {
+		t.Errorf("got %s for %s, want %s", got, base, desc.Digest)
+	}
+	if got := images.Elems[missing]; !got.Equal(prior.Elems[missing]) {
+		t.Errorf("expected the digest of state to be kept for %s, got %s", missing, got)
+	}
+	if diags.WarningsCount() != 1 {
+		t.Errorf("expected 1 warning, got %d", diags.WarningsCount())
+	}
+
+	// Without a prior digest the image is resolved again when applying
+	images, diags = baseImages(ctx, rc, dt, map[string]string{}, types.Map{ElemType: types.StringType, Null: true})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if got := images.Elems[missing]; !got.IsUnknown() {
+		t.Fatalf("expected %s to be unknown, got %s", missing, got)
+	}
+
+	args := &builtArguments{BaseImages: images}
+	diags = args.resolveBaseImages(ctx, rc, map[string]string{})
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if got := args.BaseImages.Elems[missing]; !got.IsNull() {
+		t.Errorf("expected %s to be left unpinned, got %s", missing, got)
+	}
+	if got := args.BaseImages.Elems[base]; !got.Equal(types.String{Value: desc.Digest.String()}) {
+		t.Errorf("got %s for %s, want %s", got, base, desc.Digest)
+	}
+}
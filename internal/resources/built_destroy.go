@@ -0,0 +1,191 @@
+package resources
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	onDestroyKeep           = "keep"
+	onDestroyDeleteTag      = "delete_tag"
+	onDestroyDeleteManifest = "delete_manifest"
+	onDestroyRemoveDir      = "remove_dir"
+)
+
+// outputEntry is the parsed form of the output attribute. Contrary to
+// build.ParseOutput no destination files are opened.
+type outputEntry struct {
+	Type  string
+	Attrs map[string]string
+}
+
+func parseOutputEntry(s string) (*outputEntry, error) {
+	fields, err := csv.NewReader(strings.NewReader(s)).Read()
+	if err != nil {
+		return nil, err
+	}
+	e := &outputEntry{
+		Attrs: map[string]string{},
+	}
+	for _, field := range fields {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid value %s", field)
+		}
+		key := strings.ToLower(parts[0])
+		if key == "type" {
+			e.Type = parts[1]
+			continue
+		}
+		e.Attrs[key] = parts[1]
+	}
+	if e.Type == "" {
+		return nil, errors.New("output requires type=<type>")
+	}
+	return e, nil
+}
+
+// pushed reports whether the exporter pushes its result to a registry.
+func (e *outputEntry) pushed() bool {
+	switch e.Type {
+	case "registry":
+		return true
+	case client.ExporterImage:
+		push, _ := strconv.ParseBool(e.Attrs["push"])
+		return push
+	}
+	return false
+}
+
+func (e *outputEntry) imageNames() []string {
+	names := []string{}
+	for _, name := range strings.Split(e.Attrs["name"], ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func (e *outputEntry) local() bool {
+	return e.Type == client.ExporterLocal || e.Type == client.ExporterTar
+}
+
+// validateOnDestroyConfig checks that the on_destroy policy fits the output.
+func validateOnDestroyConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	onDestroy := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("on_destroy"), &onDestroy)
+	if diags.HasError() {
+		return diags
+	}
+	output := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("output"), &output)
+	if diags.HasError() {
+		return diags
+	}
+
+	if onDestroy.IsNull() || onDestroy.IsUnknown() || output.IsUnknown() {
+		return nil
+	}
+
+	return validateOnDestroy(onDestroy.Value, output.IsNull(), output.Value)
+}
+
+func validateOnDestroy(onDestroy string, outputNull bool, output string) diag.Diagnostics {
+	if onDestroy == onDestroyKeep {
+		return nil
+	}
+
+	p := path.Root("on_destroy")
+	if outputNull {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(p, "Destroy policy without output", fmt.Sprintf("Policy %s requires an output to be configured", onDestroy)),
+		}
+	}
+
+	e, err := parseOutputEntry(output)
+	if err != nil {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing output failed", err.Error()),
+		}
+	}
+
+	switch onDestroy {
+	case onDestroyDeleteTag, onDestroyDeleteManifest:
+		if !e.pushed() {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(p, "Destroy policy not applicable", fmt.Sprintf("Policy %s requires an output pushing to a registry, got type=%s", onDestroy, e.Type)),
+			}
+		}
+	case onDestroyRemoveDir:
+		if !e.local() {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(p, "Destroy policy not applicable", fmt.Sprintf("Policy %s requires a local or tar output, got type=%s", onDestroy, e.Type)),
+			}
+		}
+		if e.Attrs["dest"] == "" || e.Attrs["dest"] == "-" {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(p, "Destroy policy not applicable", fmt.Sprintf("Policy %s requires output dest to be set", onDestroy)),
+			}
+		}
+	}
+	return nil
+}
+
+// destroyOutput applies the on_destroy policy to the output of a build.
+func destroyOutput(ctx context.Context, rc *registry.Client, args *builtArguments) diag.Diagnostics {
+	if args.OnDestroy == nil || *args.OnDestroy == onDestroyKeep {
+		return nil
+	}
+	onDestroy := *args.OnDestroy
+
+	output := ""
+	if args.Output != nil {
+		output = *args.Output
+	}
+	diags := validateOnDestroy(onDestroy, args.Output == nil, output)
+	if diags.HasError() {
+		return diags
+	}
+
+	e, err := parseOutputEntry(output)
+	if err != nil {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing output failed", err.Error()),
+		}
+	}
+
+	switch onDestroy {
+	case onDestroyDeleteTag, onDestroyDeleteManifest:
+		for _, name := range e.imageNames() {
+			if onDestroy == onDestroyDeleteTag {
+				err = rc.DeleteTag(ctx, name)
+			} else {
+				err = rc.DeleteManifest(ctx, name)
+			}
+			// Multiple names may point to the same manifest
+			if err != nil && !registry.IsNotFound(err) {
+				diags.AddError(fmt.Sprintf("Deleting image %s failed", name), err.Error())
+			}
+		}
+	case onDestroyRemoveDir:
+		dest := e.Attrs["dest"]
+		err = os.RemoveAll(dest)
+		if err != nil {
+			diags.AddError(fmt.Sprintf("Removing output %s failed", dest), err.Error())
+		}
+	}
+	return diags
+}
@@ -0,0 +1,284 @@
+package resources
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/moby/buildkit/frontend/dockerfile/instructions"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+)
+
+const (
+	frontendDockerfile = "dockerfile.v0"
+)
+
+var (
+	// Options the dockerfile frontend understands besides the typed
+	// attributes
+	dockerfileOpts = map[string]struct{}{
+		"cache-from":          {},
+		"cache-imports":       {},
+		"cgroup-parent":       {},
+		"contextkey":          {},
+		"contextsubdir":       {},
+		"dockerfilekey":       {},
+		"filename":            {},
+		"hostname":            {},
+		"image-resolve-mode":  {},
+		"multi-platform":      {},
+		"no-cache":            {},
+		"override-copy-image": {},
+		frontendAttrAddHosts:  {},
+		frontendAttrContext:   {},
+		frontendAttrNetwork:   {},
+		frontendAttrPlatform:  {},
+		frontendAttrShmSize:   {},
+		frontendAttrTarget:    {},
+		frontendAttrUlimit:    {},
+	}
+	dockerfileOptPrefixes = []string{
+		frontendAttrBuildArgPrefix,
+		frontendAttrLabelPrefix,
+		frontendAttrNamedContextPrefix,
+	}
+
+	// Build args which are provided without being declared
+	predefinedArgs = map[string]struct{}{
+		"HTTP_PROXY":  {},
+		"HTTPS_PROXY": {},
+		"FTP_PROXY":   {},
+		"NO_PROXY":    {},
+		"ALL_PROXY":   {},
+		"http_proxy":  {},
+		"https_proxy": {},
+		"ftp_proxy":   {},
+		"no_proxy":    {},
+		"all_proxy":   {},
+		// Set by the frontend for every stage
+		"TARGETPLATFORM": {},
+		"TARGETOS":       {},
+		"TARGETARCH":     {},
+		"TARGETVARIANT":  {},
+		"BUILDPLATFORM":  {},
+		"BUILDOS":        {},
+		"BUILDARCH":      {},
+		"BUILDVARIANT":   {},
+	}
+
+	// A syntax directive hands the Dockerfile to another frontend, which
+	// may understand more than the vendored parser
+	syntaxDirective = regexp.MustCompile(`(?im)\A(?:\s*#.*\n)*?\s*#\s*syntax\s*=`)
+)
+
+// validateDockerfileConfig parses the Dockerfile and checks target, build
+// args and opts against it, as far as the config is known.
+func validateDockerfileConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	return checkDockerfile(ctx, config)
+}
+
+// checkDockerfile parses the Dockerfile of values and checks target, build
+// args and opts against it. Nothing is checked for Dockerfiles not available
+// locally.
+func checkDockerfile(ctx context.Context, values attributeGetter) diag.Diagnostics {
+	frontend := types.String{}
+	diags := values.GetAttribute(ctx, path.Root("frontend"), &frontend)
+	if diags.HasError() || frontend.IsUnknown() || frontend.Value != frontendDockerfile {
+		return diags
+	}
+
+	opts, known, diags := knownStringMap(ctx, values, path.Root("opts"))
+	if diags.HasError() || !known {
+		return diags
+	}
+	diags = checkDockerfileOpts(opts)
+
+	dt, p, ok, d := planDockerfile(ctx, values, opts)
+	diags.Append(d...)
+	if diags.HasError() || !ok || syntaxDirective.Match(dt) {
+		return diags
+	}
+
+	ast, err := parser.Parse(bytes.NewReader(dt))
+	if err != nil {
+		diags.AddAttributeError(p, "Invalid Dockerfile", err.Error())
+		return diags
+	}
+	stages, metaArgs, err := instructions.Parse(ast.AST)
+	if err != nil {
+		diags.AddAttributeError(p, "Invalid Dockerfile", err.Error())
+		return diags
+	}
+
+	diags.Append(checkDockerfileTarget(ctx, values, opts, stages)...)
+	diags.Append(checkDockerfileArgs(ctx, values, opts, stages, metaArgs)...)
+	return diags
+}
+
+// planDockerfile reads the Dockerfile of values. ok is false in case it is
+// unknown, fetched by the build or cannot be read (yet).
+func planDockerfile(ctx context.Context, values attributeGetter, opts map[string]string) (dt []byte, p path.Path, ok bool, diags diag.Diagnostics) {
+	inline := types.String{}
+	diags = values.GetAttribute(ctx, path.Root("dockerfile_inline"), &inline)
+	if diags.HasError() || inline.IsUnknown() {
+		return nil, path.Empty(), false, diags
+	}
+	if !inline.IsNull() {
+		return []byte(inline.Value), path.Root("dockerfile_inline"), true, nil
+	}
+
+	localDirs, known, diags := knownStringMap(ctx, values, path.Root("local_dirs"))
+	if diags.HasError() || !known {
+		return nil, path.Empty(), false, diags
+	}
+	name := dockerfileName(opts)
+	if dir, ok := localDirs[localNameDockerfile]; ok {
+		return readDockerfile(filepath.Join(dir, name), path.Root("local_dirs").AtMapKey(localNameDockerfile))
+	}
+
+	files, known, diags := knownContextFiles(ctx, values)
+	if diags.HasError() || !known {
+		return nil, path.Empty(), false, diags
+	}
+	if dt, ok := memDockerfile(nil, localDirs, opts, files); ok {
+		return dt, path.Root("context_files").AtMapKey(name), true, nil
+	}
+
+	if dir, ok := localDirs[localNameContext]; ok {
+		return readDockerfile(filepath.Join(dir, name), path.Root("local_dirs").AtMapKey(localNameContext))
+	}
+	return nil, path.Empty(), false, nil
+}
+
+// readDockerfile reads the Dockerfile at filename. Dockerfiles missing
+// during planning may well be created during apply.
+func readDockerfile(filename string, p path.Path) ([]byte, path.Path, bool, diag.Diagnostics) {
+	dt, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, p, false, nil
+	}
+	return dt, p, true, nil
+}
+
+// checkDockerfileOpts warns about opts not understood by the dockerfile
+// frontend. Newer frontends may understand more.
+func checkDockerfileOpts(opts map[string]string) diag.Diagnostics {
+	diags := diag.Diagnostics{}
+	for k := range opts {
+		if _, ok := dockerfileOpts[k]; ok || hasAnyPrefix(k, dockerfileOptPrefixes) {
+			continue
+		}
+		diags.AddAttributeWarning(path.Root("opts").AtMapKey(k), "Unknown frontend option", fmt.Sprintf("%s is not an option of the %s frontend", k, frontendDockerfile))
+	}
+	return diags
+}
+
+// checkDockerfileTarget checks that the target is a stage of the Dockerfile.
+func checkDockerfileTarget(ctx context.Context, values attributeGetter, opts map[string]string, stages []instructions.Stage) diag.Diagnostics {
+	target := types.String{}
+	diags := values.GetAttribute(ctx, path.Root("target"), &target)
+	if diags.HasError() || target.IsUnknown() {
+		return diags
+	}
+	p := path.Root("target")
+	if target.IsNull() {
+		v, ok := opts[frontendAttrTarget]
+		if !ok {
+			return nil
+		}
+		target.Value = v
+		p = path.Root("opts").AtMapKey(frontendAttrTarget)
+	}
+
+	names := make([]string, 0, len(stages))
+	for _, s := range stages {
+		if strings.EqualFold(s.Name, target.Value) {
+			return nil
+		}
+		if s.Name != "" {
+			names = append(names, s.Name)
+		}
+	}
+	detail := fmt.Sprintf("The Dockerfile has no stage %s", target.Value)
+	if len(names) != 0 {
+		detail += fmt.Sprintf(", stages are %s", strings.Join(names, ", "))
+	}
+	return diag.Diagnostics{
+		diag.NewAttributeErrorDiagnostic(p, "Unknown target", detail),
+	}
+}
+
+// checkDockerfileArgs warns about build args not declared with ARG, which
+// the build ignores.
+func checkDockerfileArgs(ctx context.Context, values attributeGetter, opts map[string]string, stages []instructions.Stage, metaArgs []instructions.ArgCommand) diag.Diagnostics {
+	declared := map[string]struct{}{}
+	declare := func(a *instructions.ArgCommand) {
+		for _, kv := range a.Args {
+			declared[kv.Key] = struct{}{}
+		}
+	}
+	for i := range metaArgs {
+		declare(&metaArgs[i])
+	}
+	for _, s := range stages {
+		for _, c := range s.Commands {
+			if a, ok := c.(*instructions.ArgCommand); ok {
+				declare(a)
+			}
+		}
+	}
+
+	undeclared := func(name string) bool {
+		if _, ok := declared[name]; ok {
+			return false
+		}
+		if _, ok := predefinedArgs[name]; ok {
+			return false
+		}
+		return !strings.HasPrefix(name, "BUILDKIT_")
+	}
+
+	diags := diag.Diagnostics{}
+	for _, attr := range []string{"build_args", "sensitive_build_args"} {
+		v := types.Map{}
+		diags.Append(values.GetAttribute(ctx, path.Root(attr), &v)...)
+		if diags.HasError() || v.IsUnknown() {
+			continue
+		}
+		names := make([]string, 0, len(v.Elems))
+		for name := range v.Elems {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if undeclared(name) {
+				diags.AddAttributeWarning(path.Root(attr).AtMapKey(name), "Undeclared build arg", fmt.Sprintf("The Dockerfile does not declare %s with ARG", name))
+			}
+		}
+	}
+	for k := range opts {
+		name := strings.TrimPrefix(k, frontendAttrBuildArgPrefix)
+		if name != k && undeclared(name) {
+			diags.AddAttributeWarning(path.Root("opts").AtMapKey(k), "Undeclared build arg", fmt.Sprintf("The Dockerfile does not declare %s with ARG", name))
+		}
+	}
+	return diags
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package resources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+const checkedDockerfile = `ARG BASE=alpine
+FROM $BASE AS build
+ARG VERSION
+RUN make
+
+FROM scratch
+COPY --from=build /out /
+`
+
+func stringMapValue(m map[string]string) tftypes.Value {
+	vals := make(map[string]tftypes.Value, len(m))
+	for k, v := range m {
+		vals[k] = tftypes.NewValue(tftypes.String, v)
+	}
+	return tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, vals)
+}
+
+func TestCheckDockerfile(t *testing.T) {
+	tests := map[string]struct {
+		attrs    map[string]tftypes.Value
+		errors   []path.Path
+		warnings []path.Path
+	}{
+		"valid": {
+			attrs: map[string]tftypes.Value{
+				"target":     tftypes.NewValue(tftypes.String, "build"),
+				"build_args": stringMapValue(map[string]string{"BASE": "debian", "VERSION": "1", "HTTP_PROXY": "proxy", "BUILDKIT_INLINE_CACHE": "1"}),
+				"opts":       stringMapValue(map[string]string{"build-arg:TARGETARCH": "arm64", "cache-from": "type=registry,ref=app:cache", "label:version": "1"}),
+			},
+		},
+		"unknown target": {
+			attrs: map[string]tftypes.Value{
+				"target": tftypes.NewValue(tftypes.String, "test"),
+			},
+			errors: []path.Path{path.Root("target")},
+		},
+		"unknown target of opts": {
+			attrs: map[string]tftypes.Value{
+				"opts": stringMapValue(map[string]string{"target": "test"}),
+			},
+			errors: []path.Path{path.Root("opts").AtMapKey("target")},
+		},
+		"unknown target not known yet": {
+			attrs: map[string]tftypes.Value{
+				"target": tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+			},
+		},
+		"undeclared build args": {
+			attrs: map[string]tftypes.Value{
+				"build_args":           stringMapValue(map[string]string{"VERSON": "1"}),
+				"sensitive_build_args": stringMapValue(map[string]string{"TOKEN": "secret"}),
+				"opts":                 stringMapValue(map[string]string{"build-arg:RELEASE": "1"}),
+			},
+			warnings: []path.Path{
+				path.Root("build_args").AtMapKey("VERSON"),
+				path.Root("sensitive_build_args").AtMapKey("TOKEN"),
+				path.Root("opts").AtMapKey("build-arg:RELEASE"),
+			},
+		},
+		"unknown opts": {
+			attrs: map[string]tftypes.Value{
+				"opts": stringMapValue(map[string]string{"cache-form": "type=registry,ref=app:cache"}),
+			},
+			warnings: []path.Path{path.Root("opts").AtMapKey("cache-form")},
+		},
+		"other frontend": {
+			attrs: map[string]tftypes.Value{
+				"frontend": tftypes.NewValue(tftypes.String, "gateway.v0"),
+				"target":   tftypes.NewValue(tftypes.String, "test"),
+			},
+		},
+		"syntax directive": {
+			attrs: map[string]tftypes.Value{
+				"dockerfile_inline": tftypes.NewValue(tftypes.String, "# syntax=docker/dockerfile:1\n"+checkedDockerfile),
+				"target":            tftypes.NewValue(tftypes.String, "test"),
+			},
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			attrs := map[string]tftypes.Value{
+				"frontend":          tftypes.NewValue(tftypes.String, frontendDockerfile),
+				"dockerfile_inline": tftypes.NewValue(tftypes.String, checkedDockerfile),
+			}
+			for k, v := range tt.attrs {
+				attrs[k] = v
+			}
+			config := tfsdk.Config{
+				Schema: builtSchema,
+				Raw:    builtRaw(t, attrs),
+			}
+			diags := validateDockerfileConfig(context.Background(), config)
+
+			checkDiagnostics(t, diags, diag.SeverityError, tt.errors)
+			checkDiagnostics(t, diags, diag.SeverityWarning, tt.warnings)
+		})
+	}
+}
+
+// checkDiagnostics checks that diags of severity are about exactly paths.
+func checkDiagnostics(t *testing.T, diags diag.Diagnostics, severity diag.Severity, paths []path.Path) {
+	t.Helper()
+	got := []path.Path{}
+	for _, d := range diags {
+		if d.Severity() != severity {
+			continue
+		}
+		wp, ok := d.(diag.DiagnosticWithPath)
+		if !ok {
+			t.Errorf("%s without path: %s", severity, d.Detail())
+			continue
+		}
+		got = append(got, wp.Path())
+	}
+	if len(got) != len(paths) {
+		t.Fatalf("got %s diagnostics for %v, want %v", severity, got, paths)
+	}
+	for _, want := range paths {
+		found := false
+		for _, p := range got {
+			found = found || p.Equal(want)
+		}
+		if !found {
+			t.Errorf("no %s diagnostic for %s, got %v", severity, want, got)
+		}
+	}
+}
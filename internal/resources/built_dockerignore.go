@@ -0,0 +1,48 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/input"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// contextExcludes reads the exclude patterns of the context. Same as with the
+// dockerfile frontend, an ignore file named after the Dockerfile takes
+// precedence over the .dockerignore of the context.
+func contextExcludes(contextSource buildctl.SyncedSource, dockerfileSource *buildctl.SyncedSource, opts map[string]string) ([]string, error) {
+	if dockerfileSource != nil {
+		excludes, ok, err := buildctl.ReadDockerignore(*dockerfileSource, dockerfileName(opts)+buildctl.DockerignoreFilename)
+		if err != nil || ok {
+			return excludes, err
+		}
+	}
+	excludes, _, err := buildctl.ReadDockerignore(contextSource, buildctl.DockerignoreFilename)
+	return excludes, err
+}
+
+// localContext serves dir as local name, excluding what the .dockerignore of
+// dir excludes.
+func localContext(name string, dir string) (buildctl.SyncedSource, error) {
+	src := buildctl.DirSource(name, dir)
+	excludes, _, err := buildctl.ReadDockerignore(src, buildctl.DockerignoreFilename)
+	if err != nil {
+		return buildctl.SyncedSource{}, err
+	}
+	src.Excludes = excludes
+	return src, nil
+}
+
+// contextDigest calculates the digest of the context among sources, as it is
+// uploaded. ok is false in case there is no local context.
+func contextDigest(ctx context.Context, sources []buildctl.SyncedSource) (dgst godigest.Digest, ok bool, err error) {
+	for _, src := range sources {
+		if src.Name != localNameContext {
+			continue
+		}
+		dgst, err = input.SourceDigest(ctx, src)
+		return dgst, err == nil, err
+	}
+	return "", false, nil
+}
@@ -0,0 +1,55 @@
+package resources
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+)
+
+// failureDiagnostic describes a failed vertex, pointing at the Dockerfile
+// instruction which created it where possible.
+func failureDiagnostic(args *builtArguments, f *buildctl.FailureError) diag.Diagnostic {
+	if f.Source == nil && args.LLBDefinition == nil {
+		if name, dt, ok := localDockerfile(args); ok {
+			f.Source = buildctl.DockerfileSource(name, dt, f.Vertex)
+		}
+	}
+
+	summary := "Building failed"
+	if f.Source != nil {
+		summary = fmt.Sprintf("Building failed at %s", f.Source)
+	}
+	if args.DockerfileInline != nil && f.Source != nil {
+		return diag.NewAttributeErrorDiagnostic(path.Root("dockerfile_inline"), summary, f.Detail())
+	}
+	return diag.NewErrorDiagnostic(summary, f.Detail())
+}
+
+// localDockerfile reads the Dockerfile the build uses. ok is false in case
+// it is not available locally.
+func localDockerfile(args *builtArguments) (name string, dt []byte, ok bool) {
+	name = dockerfileName(args.Opts)
+	if len(args.ContextFiles) != 0 || args.DockerfileInline != nil {
+		files, diags := parseContextFiles(args.ContextFiles)
+		if diags.HasError() {
+			return "", nil, false
+		}
+		if dt, ok := memDockerfile(args.DockerfileInline, args.LocalDirs, args.Opts, files); ok {
+			return name, dt, true
+		}
+	}
+
+	_, dockerfileDir := args.LocalDirs[localNameDockerfile]
+	_, contextDir := args.LocalDirs[localNameContext]
+	if !dockerfileDir && !contextDir {
+		return "", nil, false
+	}
+	dt, err := os.ReadFile(dockerfilePath(args.LocalDirs, args.Opts))
+	if err != nil {
+		return "", nil, false
+	}
+	return name, dt, true
+}
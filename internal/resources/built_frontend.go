@@ -0,0 +1,284 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/go-units"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/moby/buildkit/util/entitlements"
+)
+
+// Frontend attributes of the dockerfile frontend
+const (
+	frontendAttrBuildArgPrefix = "build-arg:"
+	frontendAttrLabelPrefix    = "label:"
+	frontendAttrTarget         = "target"
+	frontendAttrNetwork        = "force-network-mode"
+	frontendAttrAddHosts       = "add-hosts"
+	frontendAttrShmSize        = "shm-size"
+	frontendAttrUlimit         = "ulimit"
+)
+
+const (
+	networkDefault = "default"
+	networkNone    = "none"
+	networkHost    = "host"
+)
+
+type ulimit struct {
+	Soft int64 `tfsdk:"soft"`
+	Hard int64 `tfsdk:"hard"`
+}
+
+// dockerfileAttrs translates the typed attributes of the dockerfile frontend
+// into frontend attributes.
+func (args *builtArguments) dockerfileAttrs() (map[string]string, diag.Diagnostics) {
+	attrs := map[string]string{}
+	for k, v := range args.BuildArgs {
+		attrs[frontendAttrBuildArgPrefix+k] = v
+	}
+	for k, v := range args.SensitiveBuildArgs {
+		attrs[frontendAttrBuildArgPrefix+k] = v
+	}
+	for k, v := range args.Labels {
+		attrs[frontendAttrLabelPrefix+k] = v
+	}
+	if args.Target != nil {
+		attrs[frontendAttrTarget] = *args.Target
+	}
+	if args.Network != nil && *args.Network != networkDefault {
+		attrs[frontendAttrNetwork] = *args.Network
+	}
+	if len(args.AddHosts) != 0 {
+		attrs[frontendAttrAddHosts] = formatAddHosts(args.AddHosts)
+	}
+	if args.ShmSize != nil {
+		size, err := units.RAMInBytes(*args.ShmSize)
+		if err != nil {
+			return nil, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("shm_size"), "Invalid shm size", err.Error()),
+			}
+		}
+		attrs[frontendAttrShmSize] = strconv.FormatInt(size, 10)
+	}
+	if len(args.Ulimits) != 0 {
+		attrs[frontendAttrUlimit] = formatUlimits(args.Ulimits)
+	}
+	return attrs, nil
+}
+
+// formatAddHosts formats hosts as host=ip pairs, sorted for a stable result.
+func formatAddHosts(hosts map[string]string) string {
+	pairs := make([]string, 0, len(hosts))
+	for host, ip := range hosts {
+		pairs = append(pairs, host+"="+ip)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// formatUlimits formats limits as name=soft:hard, sorted for a stable result.
+func formatUlimits(limits map[string]ulimit) string {
+	ul := make([]string, 0, len(limits))
+	for name, l := range limits {
+		ul = append(ul, fmt.Sprintf("%s=%d:%d", name, l.Soft, l.Hard))
+	}
+	sort.Strings(ul)
+	return strings.Join(ul, ",")
+}
+
+// frontendAttrKeys returns the frontend attributes the typed attributes of
+// config set, keyed by the attribute setting them. Unknown values are
+// skipped.
+func frontendAttrKeys(ctx context.Context, config tfsdk.Config) (map[string]path.Path, diag.Diagnostics) {
+	keys := map[string]path.Path{}
+	var diags diag.Diagnostics
+	for attr, prefix := range map[string]string{
+		"build_args":           frontendAttrBuildArgPrefix,
+		"sensitive_build_args": frontendAttrBuildArgPrefix,
+		"labels":               frontendAttrLabelPrefix,
+	} {
+		v := types.Map{}
+		diags.Append(config.GetAttribute(ctx, path.Root(attr), &v)...)
+		if diags.HasError() {
+			return nil, diags
+		}
+		for k := range v.Elems {
+			p := path.Root(attr).AtMapKey(k)
+			if prev, ok := keys[prefix+k]; ok {
+				diags.AddAttributeError(p, "Conflicting frontend attribute", fmt.Sprintf("%s is already set by %s", prefix+k, prev))
+			}
+			keys[prefix+k] = p
+		}
+	}
+
+	for attr, key := range map[string]string{
+		"target":    frontendAttrTarget,
+		"network":   frontendAttrNetwork,
+		"add_hosts": frontendAttrAddHosts,
+		"shm_size":  frontendAttrShmSize,
+		"ulimits":   frontendAttrUlimit,
+	} {
+		p := path.Root(attr)
+		var null bool
+		switch attr {
+		case "add_hosts", "ulimits":
+			v := types.Map{}
+			diags.Append(config.GetAttribute(ctx, p, &v)...)
+			null = v.IsNull()
+		default:
+			v := types.String{}
+			diags.Append(config.GetAttribute(ctx, p, &v)...)
+			null = v.IsNull()
+		}
+		if diags.HasError() {
+			return nil, diags
+		}
+		if !null {
+			keys[key] = p
+		}
+	}
+	return keys, diags
+}
+
+// validateDockerfileAttrsConfig checks the typed attributes of the dockerfile
+// frontend and rejects setting the same frontend attribute through opts.
+func validateDockerfileAttrsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	keys, diags := frontendAttrKeys(ctx, config)
+	if diags.HasError() {
+		return diags
+	}
+
+	if len(keys) != 0 {
+		def := types.String{}
+		diags.Append(config.GetAttribute(ctx, path.Root("llb_definition"), &def)...)
+		if diags.HasError() {
+			return diags
+		}
+		if !def.IsNull() {
+			for _, p := range keys {
+				diags.AddAttributeError(p, "Conflicting build definition", "Frontend attributes cannot be used with llb_definition")
+			}
+			return diags
+		}
+	}
+
+	opts := types.Map{}
+	diags.Append(config.GetAttribute(ctx, path.Root("opts"), &opts)...)
+	if diags.HasError() {
+		return diags
+	}
+	for k := range opts.Elems {
+		if p, ok := keys[k]; ok {
+			diags.AddAttributeError(path.Root("opts").AtMapKey(k), "Conflicting frontend attribute", fmt.Sprintf("%s is already set by %s", k, p))
+		}
+	}
+
+	for _, attr := range []string{"build_args", "sensitive_build_args", "labels"} {
+		v := types.Map{}
+		diags.Append(config.GetAttribute(ctx, path.Root(attr), &v)...)
+		for k := range v.Elems {
+			if k == "" {
+				diags.AddAttributeError(path.Root(attr), "Invalid key", "Keys cannot be empty")
+			}
+		}
+	}
+
+	diags.Append(validateNetworkConfig(ctx, config)...)
+	diags.Append(validateAddHostsConfig(ctx, config)...)
+	diags.Append(validateShmSizeConfig(ctx, config)...)
+	diags.Append(validateUlimitsConfig(ctx, config)...)
+	return diags
+}
+
+// validateNetworkConfig requires the network.host entitlement for host
+// networking.
+func validateNetworkConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	network := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("network"), &network)
+	if diags.HasError() || network.Value != networkHost {
+		return diags
+	}
+
+	allow := types.List{}
+	diags = config.GetAttribute(ctx, path.Root("allow"), &allow)
+	if diags.HasError() || allow.IsUnknown() {
+		return diags
+	}
+	for _, e := range allow.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() || s.Value == string(entitlements.EntitlementNetworkHost) {
+			return nil
+		}
+	}
+	return diag.Diagnostics{
+		diag.NewAttributeErrorDiagnostic(path.Root("network"), "Missing entitlement", fmt.Sprintf("Host networking requires %s in allow", entitlements.EntitlementNetworkHost)),
+	}
+}
+
+func validateAddHostsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	hosts := types.Map{}
+	diags := config.GetAttribute(ctx, path.Root("add_hosts"), &hosts)
+	if diags.HasError() {
+		return diags
+	}
+	for host, e := range hosts.Elems {
+		p := path.Root("add_hosts").AtMapKey(host)
+		if host == "" || strings.ContainsAny(host, "=,") {
+			diags.AddAttributeError(p, "Invalid host", fmt.Sprintf("%q is not a valid host name", host))
+		}
+		ip, ok := e.(types.String)
+		if !ok || ip.IsUnknown() || ip.IsNull() {
+			continue
+		}
+		if net.ParseIP(ip.Value) == nil {
+			diags.AddAttributeError(p, "Invalid IP address", fmt.Sprintf("%q is not a valid IP address", ip.Value))
+		}
+	}
+	return diags
+}
+
+func validateShmSizeConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	size := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("shm_size"), &size)
+	if diags.HasError() || size.IsNull() || size.IsUnknown() {
+		return diags
+	}
+	if _, err := units.RAMInBytes(size.Value); err != nil {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("shm_size"), "Invalid shm size", err.Error()),
+		}
+	}
+	return nil
+}
+
+func validateUlimitsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	limits := types.Map{}
+	diags := config.GetAttribute(ctx, path.Root("ulimits"), &limits)
+	if diags.HasError() {
+		return diags
+	}
+	for name, e := range limits.Elems {
+		obj, ok := e.(types.Object)
+		if !ok || obj.IsUnknown() {
+			continue
+		}
+		soft, _ := obj.Attrs["soft"].(types.Int64)
+		hard, _ := obj.Attrs["hard"].(types.Int64)
+		if soft.IsUnknown() || hard.IsUnknown() {
+			continue
+		}
+		if _, err := units.ParseUlimit(fmt.Sprintf("%s=%d:%d", name, soft.Value, hard.Value)); err != nil {
+			diags.AddAttributeError(path.Root("ulimits").AtMapKey(name), "Invalid ulimit", err.Error())
+		}
+	}
+	return diags
+}
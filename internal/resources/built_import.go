@@ -0,0 +1,46 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// ImportState adopts an already pushed image, e.g. `registry/repo:tag` or
+// `registry/repo@sha256:...`.
+// The digest of the local inputs cannot be known without the configuration,
+// so it is left unset and the first apply after importing always rebuilds.
+func (r *builtResource) ImportState(ctx context.Context, req tresource.ImportStateRequest, resp *tresource.ImportStateResponse) {
+
+	ref, err := registry.ParseReference(req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError("Parsing image reference failed", err.Error())
+		return
+	}
+
+	desc, err := r.registryClient().Resolve(ctx, req.ID)
+	if err != nil {
+		resp.Diagnostics.AddError(fmt.Sprintf("Resolving image %s failed", req.ID), err.Error())
+		return
+	}
+
+	// Rebuilds cannot push by digest, so fall back to the repository
+	name := ref.String()
+	if ref.Tag == "" {
+		name = reference.TrimNamed(ref.Named).String()
+	}
+
+	diags := resp.State.SetAttribute(ctx, path.Root("output"), fmt.Sprintf("type=image,name=%s,push=true", name))
+	resp.Diagnostics.Append(diags...)
+	diags = resp.State.SetAttribute(ctx, path.Root("image_digest"), desc.Digest.String())
+	resp.Diagnostics.Append(diags...)
+	diags = resp.State.SetAttribute(ctx, path.Root("image_names"), stringList([]string{name}))
+	resp.Diagnostics.Append(diags...)
+	diags = resp.State.SetAttribute(ctx, path.Root("input_digest"), types.String{Null: true})
+	resp.Diagnostics.Append(diags...)
+}
@@ -0,0 +1,376 @@
+package resources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/input"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// ModifyPlan recalculates the digest of the local inputs and pins remote
+// sources, so that changed inputs result in a rebuild.
+func (r *builtResource) ModifyPlan(ctx context.Context, req tresource.ModifyPlanRequest, resp *tresource.ModifyPlanResponse) {
+
+	// Nothing to calculate when destroying
+	if req.Plan.Raw.IsNull() {
+		return
+	}
+
+	if r.client != nil {
+		diags := checkWorkerPlatforms(ctx, r.client, req.Plan)
+		resp.Diagnostics.Append(diags...)
+		if resp.Diagnostics.HasError() {
+			return
+		}
+	}
+
+	diags := planSensitiveTriggerHashes(ctx, req, resp)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	gitCommit, diags := planGitCommit(ctx, req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = resp.Plan.SetAttribute(ctx, path.Root("git_commit"), gitCommit)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	httpDigest, diags := planHTTPDigest(ctx, r.registryClient(), req.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = resp.Plan.SetAttribute(ctx, path.Root("http_digest"), httpDigest)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = planNamedContextSources(ctx, r.registryClient(), &resp.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	// Warnings were reported during validation already
+	for _, d := range checkDockerfile(ctx, resp.Plan) {
+		if d.Severity() == diag.SeverityError {
+			resp.Diagnostics.Append(d)
+		}
+	}
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = planBaseImages(ctx, r.registryClient(), req.State, &resp.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	inputDigest, diags := planInputDigest(ctx, resp.Plan)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	diags = resp.Plan.SetAttribute(ctx, path.Root("input_digest"), inputDigest)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() || req.State.Raw.IsNull() {
+		return
+	}
+
+	prior := types.String{}
+	diags = req.State.GetAttribute(ctx, path.Root("input_digest"), &prior)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	baseImages, priorBaseImages := types.Map{ElemType: types.StringType}, types.Map{ElemType: types.StringType}
+	resp.Diagnostics.Append(resp.Plan.GetAttribute(ctx, path.Root("base_images"), &baseImages)...)
+	resp.Diagnostics.Append(req.State.GetAttribute(ctx, path.Root("base_images"), &priorBaseImages)...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	modified, diags := planOutputModified(ctx, resp.Plan, req.State)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() || prior.Equal(inputDigest) && priorBaseImages.Equal(baseImages) && !modified {
+		return
+	}
+
+	// Changed inputs, moved base images or a modified output result in a
+	// new build
+	for _, name := range []string{"image_digest", "metadata", "output_digest", "provenance"} {
+		diags = resp.Plan.SetAttribute(ctx, path.Root(name), types.String{Unknown: true})
+		resp.Diagnostics.Append(diags...)
+	}
+	diags = resp.Plan.SetAttribute(ctx, path.Root("platform_digests"), types.Map{ElemType: types.StringType, Unknown: true})
+	resp.Diagnostics.Append(diags...)
+}
+
+func planInputDigest(ctx context.Context, plan tfsdk.Plan) (types.String, diag.Diagnostics) {
+	dgst, diags := planBuildDigest(ctx, plan)
+	if diags.HasError() || dgst.IsUnknown() || dgst.IsNull() {
+		return dgst, diags
+	}
+
+	digests, known, diags := planNamedContextDigests(ctx, plan)
+	if diags.HasError() || !known {
+		return types.String{Unknown: true}, diags
+	}
+	layouts, known, diags := planOCILayoutDigests(ctx, plan)
+	if diags.HasError() || !known {
+		return types.String{Unknown: true}, diags
+	}
+	if digests == nil {
+		digests = make(map[string]godigest.Digest, len(layouts))
+	}
+	for name, dgst := range layouts {
+		digests[name] = dgst
+	}
+	if len(digests) == 0 {
+		return dgst, nil
+	}
+
+	digests["build"] = godigest.Digest(dgst.Value)
+	return types.String{Value: input.Combine(digests).String()}, nil
+}
+
+// planBuildDigest calculates the digest of the build definition and its
+// main context.
+func planBuildDigest(ctx context.Context, plan tfsdk.Plan) (types.String, diag.Diagnostics) {
+	def := types.String{}
+	diags := plan.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() || def.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	if !def.IsNull() {
+		dt, diags := parseLLBDefinition(def.Value)
+		if diags.HasError() {
+			return types.String{}, diags
+		}
+		return types.String{Value: input.DefinitionDigest(dt).String()}, nil
+	}
+
+	frontend := types.String{}
+	diags = plan.GetAttribute(ctx, path.Root("frontend"), &frontend)
+	if diags.HasError() {
+		return types.String{}, diags
+	}
+	localDirs, known, diags := knownStringMap(ctx, plan, path.Root("local_dirs"))
+	if diags.HasError() || !known {
+		return types.String{Unknown: true}, diags
+	}
+	opts, known, diags := knownStringMap(ctx, plan, path.Root("opts"))
+	if diags.HasError() || !known {
+		return types.String{Unknown: true}, diags
+	}
+
+	if frontend.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+	if frontend.Value != frontendDockerfile {
+		tflog.Warn(ctx, "Input caching not yet implemented", map[string]interface{}{
+			"frontend": frontend.Value,
+		})
+		return types.String{Null: true}, nil
+	}
+
+	inline := types.String{}
+	diags = plan.GetAttribute(ctx, path.Root("dockerfile_inline"), &inline)
+	if diags.HasError() || inline.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	contextFiles, known, diags := knownContextFiles(ctx, plan)
+	if diags.HasError() || !known {
+		return types.String{Unknown: true}, diags
+	}
+
+	var dockerfileInline *string
+	if !inline.IsNull() {
+		dockerfileInline = &inline.Value
+	}
+
+	remote, diags := planRemoteContext(ctx, plan)
+	if diags.HasError() || remote.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	if !remote.IsNull() {
+		content := types.String{}
+		diags = plan.GetAttribute(ctx, path.Root("http_digest"), &content)
+		if diags.HasError() || content.IsUnknown() {
+			return types.String{Unknown: true}, diags
+		}
+		return remoteInputDigest(remote.Value, content, dockerfileInline, localDirs, opts)
+	}
+
+	_, sources, err := locals(localDirs, dockerfileInline, contextFiles, opts)
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs"), "Reading .dockerignore failed", err.Error()),
+		}
+	}
+
+	dockerfile, ok := memDockerfile(dockerfileInline, localDirs, opts, contextFiles)
+	if !ok {
+		dockerfile, err = os.ReadFile(dockerfilePath(localDirs, opts))
+	}
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs"), "Calculating input digest failed", err.Error()),
+		}
+	}
+	digests := map[string]godigest.Digest{
+		"dockerfile": input.ContentDigest(dockerfile),
+	}
+
+	// The context is hashed as uploaded, i.e. without what .dockerignore
+	// excludes
+	dgst, ok, err := contextDigest(ctx, sources)
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs"), "Calculating input digest failed", err.Error()),
+		}
+	}
+	if ok {
+		digests["context"] = dgst
+	}
+	return types.String{Value: input.Combine(digests).String()}, nil
+}
+
+// remoteInputDigest calculates the input digest for a context fetched by the
+// build itself. Files referenced by the Dockerfile are part of that context,
+// so only the pinned context and the Dockerfile itself are hashed. content is
+// the digest of an http context, as the URL alone does not pin it.
+func remoteInputDigest(remote string, content types.String, inline *string, localDirs map[string]string, opts map[string]string) (types.String, diag.Diagnostics) {
+	digests := map[string]godigest.Digest{
+		"context": input.RemoteContextDigest(remote),
+	}
+	if !content.IsNull() {
+		digests["http"] = godigest.Digest(content.Value)
+	}
+
+	if inline != nil {
+		digests["dockerfile"] = input.ContentDigest([]byte(*inline))
+	} else if dir, ok := localDirs[localNameDockerfile]; ok {
+		dt, err := os.ReadFile(filepath.Join(dir, dockerfileName(opts)))
+		if err != nil {
+			return types.String{}, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("local_dirs").AtMapKey(localNameDockerfile), "Calculating input digest failed", err.Error()),
+			}
+		}
+		digests["dockerfile"] = input.ContentDigest(dt)
+	}
+
+	return types.String{Value: input.Combine(digests).String()}, nil
+}
+
+// memDockerfile returns the content of the Dockerfile, in case it is held
+// in memory instead of on disk.
+func memDockerfile(inline *string, localDirs map[string]string, opts map[string]string, contextFiles map[string]buildctl.MemFile) ([]byte, bool) {
+	if inline != nil {
+		return []byte(*inline), true
+	}
+	if _, ok := localDirs[localNameDockerfile]; ok {
+		return nil, false
+	}
+	f, ok := contextFiles[buildctl.CleanMemPath(dockerfileName(opts))]
+	return f.Data, ok
+}
+
+// knownContextFiles reads context_files from values. known is false in case
+// any of the files is not known yet.
+func knownContextFiles(ctx context.Context, values attributeGetter) (files map[string]buildctl.MemFile, known bool, diags diag.Diagnostics) {
+	v := types.Map{}
+	diags = values.GetAttribute(ctx, path.Root("context_files"), &v)
+	if diags.HasError() || v.IsUnknown() {
+		return nil, false, diags
+	}
+
+	planned := map[string]struct {
+		Content types.String `tfsdk:"content"`
+		Mode    types.String `tfsdk:"mode"`
+	}{}
+	diags = v.ElementsAs(ctx, &planned, false)
+	if diags.HasError() {
+		return nil, false, diags
+	}
+
+	cf := make(map[string]contextFile, len(planned))
+	for p, f := range planned {
+		if f.Content.IsUnknown() || f.Mode.IsUnknown() {
+			return nil, false, diags
+		}
+		file := contextFile{
+			Content: f.Content.Value,
+		}
+		if !f.Mode.IsNull() {
+			file.Mode = &f.Mode.Value
+		}
+		cf[p] = file
+	}
+
+	files, diags = parseContextFiles(cf)
+	return files, !diags.HasError(), diags
+}
+
+// dockerfilePath finds the Dockerfile the dockerfile frontend will use.
+func dockerfilePath(localDirs map[string]string, opts map[string]string) string {
+	dir, ok := localDirs[localNameDockerfile]
+	if !ok {
+		dir = localDirs[localNameContext]
+	}
+	return filepath.Join(dir, dockerfileName(opts))
+}
+
+// dockerfileName is the name of the Dockerfile within the dockerfile local.
+func dockerfileName(opts map[string]string) string {
+	filename, ok := opts["filename"]
+	if !ok {
+		filename = "Dockerfile"
+	}
+	return filename
+}
+
+// attributeGetter reads attributes of a config, plan or state, so that
+// checks can run during validation as well as planning.
+type attributeGetter interface {
+	GetAttribute(ctx context.Context, p path.Path, target interface{}) diag.Diagnostics
+}
+
+// knownStringMap reads a map of strings from values. known is false in case
+// the map or any of its values is not known yet.
+func knownStringMap(ctx context.Context, values attributeGetter, p path.Path) (m map[string]string, known bool, diags diag.Diagnostics) {
+	v := types.Map{}
+	diags = values.GetAttribute(ctx, p, &v)
+	if diags.HasError() || v.IsUnknown() {
+		return nil, false, diags
+	}
+
+	m = make(map[string]string, len(v.Elems))
+	for k, e := range v.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() {
+			return nil, false, diags
+		}
+		m[k] = s.Value
+	}
+	return m, true, diags
+}
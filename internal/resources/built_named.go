@@ -0,0 +1,312 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/input"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/git"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// Prefix of frontend attributes defining named contexts
+	frontendAttrNamedContextPrefix = "context:"
+
+	// Prefix of locals serving local named contexts
+	localNameNamedContextPrefix = "named-context-"
+)
+
+var (
+	namedContextSources = []string{"local", "docker_image", "git", "oci_layout"}
+)
+
+type namedContext struct {
+	Local       *string           `tfsdk:"local"`
+	DockerImage *string           `tfsdk:"docker_image"`
+	Git         *gitContext       `tfsdk:"git"`
+	OCILayout   *ociLayoutContext `tfsdk:"oci_layout"`
+
+	Source types.String `tfsdk:"source"`
+}
+
+type ociLayoutContext struct {
+	Path string  `tfsdk:"path"`
+	Tag  *string `tfsdk:"tag"`
+}
+
+func (c *ociLayoutContext) tag() string {
+	if c.Tag == nil {
+		return "latest"
+	}
+	return *c.Tag
+}
+
+// ociStoreID is the ID of the content store serving the OCI layout of the
+// named context name. It has to be a valid repository name.
+func ociStoreID(name string) string {
+	return localNameNamedContextPrefix + godigest.FromString(name).Encoded()[:12]
+}
+
+// resolve pins the source of the named context name to a commit or digest,
+// in the form the dockerfile frontend expects.
+func (nc *namedContext) resolve(ctx context.Context, rc *registry.Client, name string) (string, error) {
+	switch {
+	case nc.Local != nil:
+		return "local:" + localNameNamedContextPrefix + name, nil
+	case nc.DockerImage != nil:
+		ref, err := registry.ParseReference(*nc.DockerImage)
+		if err != nil {
+			return "", err
+		}
+		if ref.Digest != "" {
+			return "docker-image://" + ref.String(), nil
+		}
+		desc, err := rc.ResolveMirrored(ctx, ref.String())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("docker-image://%s@%s", ref, desc.Digest), nil
+	case nc.Git != nil:
+		ref := ""
+		if nc.Git.Ref != nil {
+			ref = *nc.Git.Ref
+		}
+		commit, err := git.ResolveRef(ctx, nc.Git.URL, ref)
+		if err != nil {
+			return "", err
+		}
+		subdir := ""
+		if nc.Git.Subdir != nil {
+			subdir = *nc.Git.Subdir
+		}
+		return pinnedGitContext(nc.Git.URL, commit, subdir), nil
+	case nc.OCILayout != nil:
+		dgst, err := buildctl.ResolveOCILayout(nc.OCILayout.Path, nc.OCILayout.tag())
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("oci-layout://%s@%s", ociStoreID(name), dgst), nil
+	}
+	return "", fmt.Errorf("no source set")
+}
+
+// digest calculates the digest of the pinned source of the named context.
+// Local directories are included by content, as uploaded.
+func (nc *namedContext) digest(ctx context.Context, name string) (godigest.Digest, error) {
+	src := input.RemoteContextDigest(nc.Source.Value)
+	if nc.Local == nil {
+		return src, nil
+	}
+	lc, err := localContext(localNameNamedContextPrefix+name, *nc.Local)
+	if err != nil {
+		return "", err
+	}
+	dir, err := input.SourceDigest(ctx, lc)
+	if err != nil {
+		return "", err
+	}
+	return input.Combine(map[string]godigest.Digest{
+		"source": src,
+		"local":  dir,
+	}), nil
+}
+
+// parseNamedContexts translates the named contexts into frontend attributes,
+// local sources and OCI stores. Sources not pinned during planning are
+// resolved.
+func parseNamedContexts(ctx context.Context, rc *registry.Client, args *builtArguments, frontendAttrs map[string]string, localDirs map[string]string) (map[string]string, []buildctl.SyncedSource, diag.Diagnostics) {
+	ociStores := map[string]string{}
+	sources := []buildctl.SyncedSource{}
+	for name, nc := range args.NamedContexts {
+		p := path.Root("named_context").AtMapKey(name)
+		if nc.Source.IsUnknown() || nc.Source.IsNull() {
+			src, err := nc.resolve(ctx, rc, name)
+			if err != nil {
+				return nil, nil, diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(p, "Resolving named context failed", err.Error()),
+				}
+			}
+			nc.Source = types.String{Value: src}
+			args.NamedContexts[name] = nc
+		}
+
+		frontendAttrs[frontendAttrNamedContextPrefix+name] = nc.Source.Value
+		switch {
+		case nc.Local != nil:
+			local := localNameNamedContextPrefix + name
+			if _, ok := localDirs[local]; ok {
+				return nil, nil, diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(p, "Conflicting local", fmt.Sprintf("local %s is already defined by local_dirs", local)),
+				}
+			}
+			src, err := localContext(local, *nc.Local)
+			if err != nil {
+				return nil, nil, diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(p.AtName("local"), "Reading .dockerignore failed", err.Error()),
+				}
+			}
+			sources = append(sources, src)
+		case nc.OCILayout != nil:
+			ociStores[ociStoreID(name)] = nc.OCILayout.Path
+		}
+	}
+	return ociStores, sources, nil
+}
+
+// planNamedContextSources pins the sources of all named contexts known at
+// plan time, so that moved refs and tags show up as a change.
+func planNamedContextSources(ctx context.Context, rc *registry.Client, plan *tfsdk.Plan) diag.Diagnostics {
+	contexts, diags := knownNamedContexts(ctx, *plan)
+	if diags.HasError() {
+		return diags
+	}
+
+	for name, nc := range contexts {
+		if nc == nil {
+			continue
+		}
+		p := path.Root("named_context").AtMapKey(name)
+		src, err := nc.resolve(ctx, rc, name)
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(p, "Resolving named context failed", err.Error()),
+			}
+		}
+		diags = plan.SetAttribute(ctx, p.AtName("source"), src)
+		if diags.HasError() {
+			return diags
+		}
+	}
+	return nil
+}
+
+// planNamedContextDigests calculates the digests of all named contexts of
+// plan, keyed for input.Combine. known is false in case any of them is not
+// known yet.
+func planNamedContextDigests(ctx context.Context, plan tfsdk.Plan) (digests map[string]godigest.Digest, known bool, diags diag.Diagnostics) {
+	contexts, diags := knownNamedContexts(ctx, plan)
+	if diags.HasError() || contexts == nil {
+		return nil, contexts != nil, diags
+	}
+
+	digests = make(map[string]godigest.Digest, len(contexts))
+	for name, nc := range contexts {
+		if nc == nil || nc.Source.IsUnknown() {
+			return nil, false, nil
+		}
+		dgst, err := nc.digest(ctx, name)
+		if err != nil {
+			return nil, false, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("named_context").AtMapKey(name), "Calculating input digest failed", err.Error()),
+			}
+		}
+		digests["named_context:"+name] = dgst
+	}
+	return digests, true, nil
+}
+
+// knownNamedContexts reads named_context from plan. Contexts with unknown
+// configuration are nil. The map itself is nil if it is not known yet.
+func knownNamedContexts(ctx context.Context, plan tfsdk.Plan) (map[string]*namedContext, diag.Diagnostics) {
+	v := types.Map{}
+	diags := plan.GetAttribute(ctx, path.Root("named_context"), &v)
+	if diags.HasError() || v.IsUnknown() {
+		return nil, diags
+	}
+
+	contexts := make(map[string]*namedContext, len(v.Elems))
+	for name, e := range v.Elems {
+		obj, ok := e.(types.Object)
+		if !ok || obj.IsUnknown() || !knownAttrs(ctx, obj, namedContextSources) {
+			contexts[name] = nil
+			continue
+		}
+		nc := &namedContext{}
+		diags = obj.As(ctx, nc, types.ObjectAsOptions{})
+		if diags.HasError() {
+			return nil, diags
+		}
+		contexts[name] = nc
+	}
+	return contexts, nil
+}
+
+// knownAttrs checks whether the given attributes of obj are fully known.
+func knownAttrs(ctx context.Context, obj types.Object, names []string) bool {
+	for _, name := range names {
+		a, ok := obj.Attrs[name]
+		if !ok {
+			continue
+		}
+		v, err := a.ToTerraformValue(ctx)
+		if err != nil || !v.IsFullyKnown() {
+			return false
+		}
+	}
+	return true
+}
+
+// validateNamedContextsConfig checks that every named context has exactly one
+// source and is not defined through opts as well.
+func validateNamedContextsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	v := types.Map{}
+	diags := config.GetAttribute(ctx, path.Root("named_context"), &v)
+	if diags.HasError() || v.IsNull() || v.IsUnknown() {
+		return diags
+	}
+
+	def := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() {
+		return diags
+	}
+	if !def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("named_context"), "Conflicting build definition", "named_context requires a frontend and cannot be used with llb_definition"),
+		}
+	}
+
+	opts := types.Map{}
+	diags = config.GetAttribute(ctx, path.Root("opts"), &opts)
+	if diags.HasError() {
+		return diags
+	}
+
+	for name, e := range v.Elems {
+		p := path.Root("named_context").AtMapKey(name)
+		if name == "" {
+			diags.AddAttributeError(p, "Invalid named context", "Name of a named context cannot be empty")
+			continue
+		}
+		if _, ok := opts.Elems[frontendAttrNamedContextPrefix+name]; ok {
+			diags.AddAttributeError(p, "Conflicting named context", fmt.Sprintf("Named context %s is also defined in opts", name))
+		}
+
+		obj, ok := e.(types.Object)
+		if !ok || obj.IsUnknown() {
+			continue
+		}
+		set := 0
+		for _, src := range namedContextSources {
+			a, ok := obj.Attrs[src]
+			if ok && !a.IsNull() {
+				set++
+			}
+		}
+		if set != 1 {
+			diags.AddAttributeError(p, "Invalid named context", fmt.Sprintf("Exactly one of %s has to be set", strings.Join(namedContextSources, ", ")))
+		}
+		if a, ok := obj.Attrs["oci_layout"]; ok && !a.IsNull() {
+			diags.AddAttributeWarning(p.AtName("oci_layout"), "Requires BuildKit v0.11", "OCI layout named contexts are served as session content stores, which BuildKit daemons before v0.11 do not query. Builds on older daemons fail to resolve them.")
+		}
+	}
+	return diags
+}
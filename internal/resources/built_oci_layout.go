@@ -0,0 +1,113 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/input"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// ociStoreIDPattern matches store IDs, which are referenced like a
+// repository name in `oci-layout://<store-id>@<digest>`.
+var ociStoreIDPattern = regexp.MustCompile(`^[a-z0-9]+(?:[._-][a-z0-9]+)*$`)
+
+// parseOCILayout splits an oci_layout entry of the form `<store-id>=<path>`.
+func parseOCILayout(entry string) (id string, dir string, err error) {
+	id, dir, ok := strings.Cut(entry, "=")
+	if !ok || dir == "" {
+		return "", "", fmt.Errorf("expected <store-id>=<path>, got %s", entry)
+	}
+	if !ociStoreIDPattern.MatchString(id) {
+		return "", "", fmt.Errorf("invalid store ID %s, has to consist of lowercase letters, digits and separators", id)
+	}
+	if strings.HasPrefix(id, localNameNamedContextPrefix) {
+		return "", "", fmt.Errorf("store ID %s is reserved for named contexts", id)
+	}
+	return id, dir, nil
+}
+
+// parseOCILayouts adds the OCI layouts of args to ociStores, keyed by store
+// ID.
+func parseOCILayouts(args *builtArguments, ociStores map[string]string) diag.Diagnostics {
+	for i, entry := range args.OCILayout {
+		id, dir, err := parseOCILayout(entry)
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("oci_layout").AtListIndex(i), "Invalid OCI layout", err.Error()),
+			}
+		}
+		ociStores[id] = dir
+	}
+	return nil
+}
+
+// validateOCILayoutConfig checks the format of oci_layout entries and that
+// no store ID is used twice.
+func validateOCILayoutConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	v := types.List{}
+	diags := config.GetAttribute(ctx, path.Root("oci_layout"), &v)
+	if diags.HasError() || v.IsNull() || v.IsUnknown() {
+		return diags
+	}
+
+	seen := map[string]struct{}{}
+	for i, e := range v.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() || s.IsNull() {
+			continue
+		}
+		id, _, err := parseOCILayout(s.Value)
+		if err != nil {
+			diags.AddAttributeError(path.Root("oci_layout").AtListIndex(i), "Invalid OCI layout", err.Error())
+			continue
+		}
+		if _, ok := seen[id]; ok {
+			diags.AddAttributeError(path.Root("oci_layout").AtListIndex(i), "Duplicate OCI layout", fmt.Sprintf("Store ID %s is used more than once", id))
+		}
+		seen[id] = struct{}{}
+	}
+	return diags
+}
+
+// planOCILayoutDigests calculates the digests of the indexes of all OCI
+// layouts. known is false in case any of the layouts is not known yet.
+func planOCILayoutDigests(ctx context.Context, plan tfsdk.Plan) (digests map[string]godigest.Digest, known bool, diags diag.Diagnostics) {
+	v := types.List{}
+	diags = plan.GetAttribute(ctx, path.Root("oci_layout"), &v)
+	if diags.HasError() || v.IsUnknown() {
+		return nil, false, diags
+	}
+
+	digests = make(map[string]godigest.Digest, len(v.Elems))
+	for i, e := range v.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() {
+			return nil, false, nil
+		}
+		id, dir, err := parseOCILayout(s.Value)
+		if err != nil {
+			return nil, false, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("oci_layout").AtListIndex(i), "Invalid OCI layout", err.Error()),
+			}
+		}
+		dgst, err := input.OCILayoutDigest(dir)
+		if err != nil {
+			if os.IsNotExist(err) {
+				err = fmt.Errorf("%s is not an OCI layout: %w", dir, err)
+			}
+			return nil, false, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("oci_layout").AtListIndex(i), "Calculating input digest failed", err.Error()),
+			}
+		}
+		digests["oci_layout:"+id] = dgst
+	}
+	return digests, true, nil
+}
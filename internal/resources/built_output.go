@@ -0,0 +1,112 @@
+package resources
+
+import (
+	"context"
+	"os"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/input"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/moby/buildkit/client"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// outputDest returns the destination of local and tar outputs. ok is false
+// for other outputs and outputs streamed to stdout.
+func outputDest(output *string) (dest string, typ string, ok bool) {
+	if output == nil {
+		return "", "", false
+	}
+	e, err := parseOutputEntry(*output)
+	if err != nil || !e.local() {
+		return "", "", false
+	}
+	dest = e.Attrs["dest"]
+	if dest == "" || dest == "-" {
+		return "", "", false
+	}
+	return dest, e.Type, true
+}
+
+// outputDigest calculates the digest of the exported directory tree or
+// tarball. Null for outputs not written to disk.
+func outputDigest(output *string) (types.String, error) {
+	dest, typ, ok := outputDest(output)
+	if !ok {
+		return types.String{Null: true}, nil
+	}
+	var dgst godigest.Digest
+	var err error
+	if typ == client.ExporterTar {
+		dgst, err = input.FileDigest(dest)
+	} else {
+		dgst, err = input.DirDigest(dest)
+	}
+	if err != nil {
+		return types.String{}, err
+	}
+	return types.String{Value: dgst.String()}, nil
+}
+
+// outputModified recomputes the digest of output and compares it against
+// prior. Deleted outputs count as modified.
+func outputModified(ctx context.Context, output *string, prior types.String) (bool, diag.Diagnostics) {
+	current, err := outputDigest(output)
+	if err != nil && !os.IsNotExist(err) {
+		return false, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Calculating output digest failed", err.Error()),
+		}
+	}
+	if err == nil && current.Equal(prior) {
+		return false, nil
+	}
+
+	tflog.Warn(ctx, "Output was modified or deleted", map[string]interface{}{
+		"output": *output,
+	})
+	return true, nil
+}
+
+// refreshOutputDigest unsets output_digest in case the output was modified
+// or deleted since building, so that refreshing shows the drift.
+func (args *builtArguments) refreshOutputDigest(ctx context.Context) diag.Diagnostics {
+	if args.OutputDigest.IsNull() || args.OutputDigest.IsUnknown() {
+		return nil
+	}
+	if _, _, ok := outputDest(args.Output); !ok {
+		return nil
+	}
+	modified, diags := outputModified(ctx, args.Output, args.OutputDigest)
+	if modified {
+		args.OutputDigest = types.String{Null: true}
+	}
+	return diags
+}
+
+// planOutputModified checks whether the output was modified or deleted
+// since building. Either refreshing unset output_digest already or the
+// digest recorded in state does not match the output anymore. Modified or
+// deleted outputs are rebuilt.
+func planOutputModified(ctx context.Context, plan tfsdk.Plan, state tfsdk.State) (bool, diag.Diagnostics) {
+	output := types.String{}
+	diags := plan.GetAttribute(ctx, path.Root("output"), &output)
+	if diags.HasError() || output.IsNull() || output.IsUnknown() {
+		return false, diags
+	}
+	if _, _, ok := outputDest(&output.Value); !ok {
+		return false, nil
+	}
+
+	prior := types.String{}
+	diags = state.GetAttribute(ctx, path.Root("output_digest"), &prior)
+	if diags.HasError() || prior.IsUnknown() {
+		return false, diags
+	}
+	if prior.IsNull() {
+		return true, nil
+	}
+	return outputModified(ctx, &output.Value, prior)
+}
@@ -0,0 +1,88 @@
+package resources
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+func TestRefreshOutputDigest(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app"), []byte("v1"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	output := "type=local,dest=" + dir
+	built, err := outputDigest(&output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	args := &builtArguments{Output: &output, OutputDigest: built}
+	if diags := args.refreshOutputDigest(ctx); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !args.OutputDigest.Equal(built) {
+		t.Fatalf("expected unmodified output to keep its digest, got %s", args.OutputDigest)
+	}
+
+	plan := tfsdk.Plan{
+		Schema: builtSchema,
+		Raw: builtRaw(t, map[string]tftypes.Value{
+			"output": tftypes.NewValue(tftypes.String, output),
+		}),
+	}
+	state := func(dgst types.String) tfsdk.State {
+		var v tftypes.Value
+		if dgst.IsNull() {
+			v = tftypes.NewValue(tftypes.String, nil)
+		} else {
+			v = tftypes.NewValue(tftypes.String, dgst.Value)
+		}
+		return tfsdk.State{
+			Schema: builtSchema,
+			Raw: builtRaw(t, map[string]tftypes.Value{
+				"output":        tftypes.NewValue(tftypes.String, output),
+				"output_digest": v,
+			}),
+		}
+	}
+	if modified, diags := planOutputModified(ctx, plan, state(built)); diags.HasError() || modified {
+		t.Fatalf("expected unmodified output not to be rebuilt, got %t %v", modified, diags)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "app"), []byte("v2"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// Planning without refreshing compares against state
+	if modified, diags := planOutputModified(ctx, plan, state(built)); diags.HasError() || !modified {
+		t.Fatalf("expected modified output to be rebuilt, got %t %v", modified, diags)
+	}
+
+	if diags := args.refreshOutputDigest(ctx); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !args.OutputDigest.IsNull() {
+		t.Fatalf("expected refreshing to unset the digest of the modified output, got %s", args.OutputDigest)
+	}
+	if modified, diags := planOutputModified(ctx, plan, state(args.OutputDigest)); diags.HasError() || !modified {
+		t.Fatalf("expected refreshed output to be rebuilt, got %t %v", modified, diags)
+	}
+
+	// Deleted outputs are modified as well
+	args.OutputDigest = built
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	if diags := args.refreshOutputDigest(ctx); diags.HasError() {
+		t.Fatal(diags)
+	}
+	if !args.OutputDigest.IsNull() {
+		t.Fatalf("expected refreshing to unset the digest of the deleted output, got %s", args.OutputDigest)
+	}
+}
@@ -0,0 +1,172 @@
+package resources
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/containerd/containerd/platforms"
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/moby/buildkit/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// Frontend attribute of the dockerfile frontend for target platforms
+	frontendAttrPlatform = "platform"
+)
+
+// validatePlatformsConfig checks that platforms are valid specifiers and not
+// defined through opts as well.
+func validatePlatformsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	v := types.List{}
+	diags := config.GetAttribute(ctx, path.Root("platforms"), &v)
+	if diags.HasError() || v.IsNull() || v.IsUnknown() {
+		return diags
+	}
+
+	def := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() {
+		return diags
+	}
+	if !def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("platforms"), "Conflicting build definition", "platforms requires a frontend and cannot be used with llb_definition"),
+		}
+	}
+
+	opts := types.Map{}
+	diags = config.GetAttribute(ctx, path.Root("opts"), &opts)
+	if diags.HasError() {
+		return diags
+	}
+	if _, ok := opts.Elems[frontendAttrPlatform]; ok {
+		diags.AddAttributeError(path.Root("opts").AtMapKey(frontendAttrPlatform), "Conflicting platforms", "opts[\"platform\"] cannot be set together with platforms")
+	}
+
+	for i, e := range v.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() || s.IsNull() {
+			continue
+		}
+		if _, err := platforms.Parse(s.Value); err != nil {
+			diags.AddAttributeError(path.Root("platforms").AtListIndex(i), "Invalid platform", err.Error())
+		}
+	}
+	return diags
+}
+
+// checkWorkerPlatforms fails for platforms of plan, that none of the workers
+// of c is able to build.
+func checkWorkerPlatforms(ctx context.Context, c *client.Client, plan tfsdk.Plan) diag.Diagnostics {
+	v := types.List{}
+	diags := plan.GetAttribute(ctx, path.Root("platforms"), &v)
+	if diags.HasError() || v.IsNull() || v.IsUnknown() || len(v.Elems) == 0 {
+		return diags
+	}
+
+	workers, err := c.ListWorkers(ctx)
+	if err != nil {
+		return diag.Diagnostics{
+			diag.NewErrorDiagnostic("Listing workers failed", err.Error()),
+		}
+	}
+
+	supported := []ocispec.Platform{}
+	for _, w := range workers {
+		supported = append(supported, w.Platforms...)
+	}
+
+	for i, e := range v.Elems {
+		s, ok := e.(types.String)
+		if !ok || s.IsUnknown() || s.IsNull() {
+			continue
+		}
+		p, err := platforms.Parse(s.Value)
+		if err != nil {
+			diags.AddAttributeError(path.Root("platforms").AtListIndex(i), "Invalid platform", err.Error())
+			continue
+		}
+		if !workerSupports(supported, p) {
+			formatted := make([]string, 0, len(supported))
+			for _, sp := range supported {
+				formatted = append(formatted, platforms.Format(sp))
+			}
+			diags.AddAttributeError(path.Root("platforms").AtListIndex(i), "Unsupported platform", fmt.Sprintf("No worker supports %s, supported are %s", s.Value, strings.Join(formatted, ", ")))
+		}
+	}
+	return diags
+}
+
+func workerSupports(supported []ocispec.Platform, p ocispec.Platform) bool {
+	for _, sp := range supported {
+		if platforms.Only(sp).Match(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// platformDigests looks up the manifest digest of every platform of a pushed
+// image. Null for images which are not pushed.
+func platformDigests(ctx context.Context, rc *registry.Client, args *builtArguments) (types.Map, diag.Diagnostics) {
+	null := types.Map{ElemType: types.StringType, Null: true}
+	if args.Output == nil || args.ImageDigest.IsNull() || args.ImageDigest.IsUnknown() || len(args.ImageNames.Elems) == 0 {
+		return null, nil
+	}
+	e, err := parseOutputEntry(*args.Output)
+	if err != nil || !e.pushed() {
+		return null, nil
+	}
+
+	name, _ := args.ImageNames.Elems[0].(types.String)
+	ref, err := registry.ParseReference(name.Value)
+	if err != nil {
+		return null, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing image name failed", err.Error()),
+		}
+	}
+	digested := fmt.Sprintf("%s@%s", reference.TrimNamed(ref.Named), args.ImageDigest.Value)
+	_, dt, err := rc.FetchManifest(ctx, digested)
+	if err != nil {
+		return null, diag.Diagnostics{
+			diag.NewErrorDiagnostic(fmt.Sprintf("Fetching manifest %s failed", digested), err.Error()),
+		}
+	}
+
+	var idx ocispec.Index
+	if err := json.Unmarshal(dt, &idx); err != nil {
+		return null, diag.Diagnostics{
+			diag.NewErrorDiagnostic(fmt.Sprintf("Parsing manifest %s failed", digested), err.Error()),
+		}
+	}
+
+	elems := map[string]attr.Value{}
+	if len(idx.Manifests) == 0 {
+		// A single manifest is only attributable to an explicit platform
+		if len(args.Platforms) != 1 {
+			return null, nil
+		}
+		p, err := platforms.Parse(args.Platforms[0])
+		if err != nil {
+			return null, nil
+		}
+		elems[platforms.Format(p)] = types.String{Value: args.ImageDigest.Value}
+	}
+	for _, m := range idx.Manifests {
+		// Skip attestations and other non-image entries
+		if m.Platform == nil || m.Platform.OS == "unknown" {
+			continue
+		}
+		elems[platforms.Format(*m.Platform)] = types.String{Value: m.Digest.String()}
+	}
+	return types.Map{ElemType: types.StringType, Elems: elems}, nil
+}
@@ -0,0 +1,249 @@
+package resources
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/signing"
+	"github.com/containerd/continuity"
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+var (
+	provenanceExportAttributes = map[string]tfsdk.Attribute{
+		"file": {
+			Type:        types.StringType,
+			Description: "File to write the provenance to, after the state is saved",
+			Optional:    true,
+		},
+		"push": {
+			Type:                types.BoolType,
+			MarkdownDescription: "Push the provenance as cosign compatible attestation next to the image, tagged `sha256-<digest>.att`",
+			Optional:            true,
+		},
+	}
+)
+
+type provenanceExport struct {
+	File *string `tfsdk:"file"`
+	Push *bool   `tfsdk:"push"`
+}
+
+func (e *provenanceExport) push() bool {
+	return e != nil && e.Push != nil && *e.Push
+}
+
+// provenance assembles the SLSA provenance of a finished build, recorded in
+// rec, as JSON.
+func (args *builtArguments) provenance(rec *buildctl.ProvenanceRecorder, frontendAttrs map[string]string, locals []string) (types.String, diag.Diagnostics) {
+	inv := buildctl.ProvenanceInvocation{
+		Parameters: buildctl.ProvenanceParameters{
+			Args:   map[string]string{},
+			Locals: locals,
+		},
+	}
+	if args.Frontend != nil {
+		inv.Parameters.Frontend = *args.Frontend
+		inv.ConfigSource.EntryPoint = dockerfileName(args.Opts)
+	}
+	stripped := false
+	for k, v := range frontendAttrs {
+		// Values of sensitive build args must not leak into the provenance
+		if strings.HasPrefix(k, frontendAttrBuildArgPrefix) {
+			if _, ok := args.SensitiveBuildArgs[strings.TrimPrefix(k, frontendAttrBuildArgPrefix)]; ok {
+				stripped = true
+				continue
+			}
+		}
+		inv.Parameters.Args[k] = v
+	}
+
+	var materials []buildctl.Material
+	switch {
+	case args.GitContext != nil:
+		inv.ConfigSource.URI = args.GitContext.URL
+		if !args.GitCommit.IsNull() && !args.GitCommit.IsUnknown() {
+			inv.ConfigSource.Digest = map[string]string{
+				"sha1": args.GitCommit.Value,
+			}
+			materials = append(materials, buildctl.Material{
+				URI:    args.GitContext.URL,
+				Digest: inv.ConfigSource.Digest,
+			})
+		}
+	case args.HTTPContext != nil:
+		inv.ConfigSource.URI = args.HTTPContext.URL
+	}
+	if !args.InputDigest.IsNull() && !args.InputDigest.IsUnknown() {
+		materials = append(materials, buildctl.NewMaterial("local:inputs", godigest.Digest(args.InputDigest.Value)))
+	}
+	for k, v := range frontendAttrs {
+		if !strings.HasPrefix(k, frontendAttrNamedContextPrefix) {
+			continue
+		}
+		if m, ok := buildctl.ImageMaterial(v); ok {
+			materials = append(materials, m)
+		}
+	}
+
+	statement := rec.Statement(args.provenanceSubjects(), inv, materials)
+	// Parameters are incomplete without the sensitive build args
+	statement.Predicate.Metadata.Completeness.Parameters = !stripped
+	dt, err := json.Marshal(statement)
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewErrorDiagnostic("Formatting provenance failed", err.Error()),
+		}
+	}
+	return types.String{Value: string(dt)}, nil
+}
+
+// provenanceSubjects lists the artifacts exported by the build.
+func (args *builtArguments) provenanceSubjects() []buildctl.Subject {
+	subjects := []buildctl.Subject{}
+	if !args.ImageDigest.IsNull() && !args.ImageDigest.IsUnknown() {
+		for _, repo := range args.imageRepos() {
+			subjects = append(subjects, buildctl.Subject{
+				Name:   repo,
+				Digest: digestSet(args.ImageDigest.Value),
+			})
+		}
+	}
+	if dest, _, ok := outputDest(args.Output); ok && !args.OutputDigest.IsNull() && !args.OutputDigest.IsUnknown() {
+		subjects = append(subjects, buildctl.Subject{
+			Name:   dest,
+			Digest: digestSet(args.OutputDigest.Value),
+		})
+	}
+	return subjects
+}
+
+// imageRepos lists the repositories of image_names without duplicates.
+func (args *builtArguments) imageRepos() []string {
+	repos := []string{}
+	seen := map[string]struct{}{}
+	for _, e := range args.ImageNames.Elems {
+		name, ok := e.(types.String)
+		if !ok {
+			continue
+		}
+		ref, err := registry.ParseReference(name.Value)
+		if err != nil {
+			continue
+		}
+		repo := reference.TrimNamed(ref.Named).String()
+		if _, ok := seen[repo]; ok {
+			continue
+		}
+		seen[repo] = struct{}{}
+		repos = append(repos, repo)
+	}
+	return repos
+}
+
+func digestSet(dgst string) map[string]string {
+	d := godigest.Digest(dgst)
+	return map[string]string{
+		d.Algorithm().String(): d.Encoded(),
+	}
+}
+
+// localNames lists the names of all locals served to the build.
+func localNames(localDirs map[string]string, sources []buildctl.SyncedSource) []string {
+	names := make([]string, 0, len(localDirs)+len(sources))
+	for name := range localDirs {
+		names = append(names, name)
+	}
+	for _, src := range sources {
+		names = append(names, src.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// pushProvenance attaches the provenance to every repository the image was
+// pushed to. The envelope is signed with key, if set.
+func pushProvenance(ctx context.Context, rc *registry.Client, args *builtArguments, key crypto.Signer) diag.Diagnostics {
+	if !args.ProvenanceExport.push() || args.ImageDigest.IsNull() || args.Provenance.IsNull() {
+		return nil
+	}
+
+	env := buildctl.NewEnvelope([]byte(args.Provenance.Value))
+	if key != nil {
+		sig, err := signing.Sign(key, signing.PAE(env.PayloadType, env.Payload))
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("signing"), "Signing provenance failed", err.Error()),
+			}
+		}
+		env.Signatures = append(env.Signatures, buildctl.Signature{Sig: sig})
+	}
+	envelope, err := json.Marshal(env)
+	if err != nil {
+		return diag.Diagnostics{
+			diag.NewErrorDiagnostic("Formatting provenance failed", err.Error()),
+		}
+	}
+	tag := buildctl.AttestationTag(godigest.Digest(args.ImageDigest.Value))
+	annotations := map[string]string{
+		"predicateType": buildctl.SLSAProvenanceType,
+	}
+
+	diags := diag.Diagnostics{}
+	for _, repo := range args.imageRepos() {
+		ref := fmt.Sprintf("%s:%s", repo, tag)
+		if _, err := rc.AppendArtifact(ctx, ref, buildctl.DSSEEnvelopeMediaType, envelope, annotations); err != nil {
+			diags.AddAttributeError(path.Root("provenance_export").AtName("push"), fmt.Sprintf("Pushing provenance to %s failed", ref), err.Error())
+		}
+	}
+	return diags
+}
+
+// writeProvenanceFile writes the provenance to provenance_export.file, if
+// set.
+func (args *builtArguments) writeProvenanceFile() diag.Diagnostics {
+	if args.ProvenanceExport == nil || args.ProvenanceExport.File == nil || args.Provenance.IsNull() {
+		return nil
+	}
+	if err := continuity.AtomicWriteFile(*args.ProvenanceExport.File, []byte(args.Provenance.Value), 0666); err != nil {
+		return diag.Diagnostics{
+			diag.NewAttributeWarningDiagnostic(path.Root("provenance_export").AtName("file"), "Writing provenance file failed", err.Error()),
+		}
+	}
+	return nil
+}
+
+// validateProvenanceExportConfig checks that provenance is only pushed for
+// pushed images.
+func validateProvenanceExportConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	push := types.Bool{}
+	diags := config.GetAttribute(ctx, path.Root("provenance_export").AtName("push"), &push)
+	if diags.HasError() || push.IsNull() || push.IsUnknown() || !push.Value {
+		return diags
+	}
+
+	output := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("output"), &output)
+	if diags.HasError() || output.IsUnknown() {
+		return diags
+	}
+	if !output.IsNull() {
+		if e, err := parseOutputEntry(output.Value); err == nil && e.pushed() {
+			return nil
+		}
+	}
+	return diag.Diagnostics{
+		diag.NewAttributeErrorDiagnostic(path.Root("provenance_export").AtName("push"), "Provenance not pushable", "Pushing provenance requires an output pushing an image"),
+	}
+}
@@ -0,0 +1,288 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/git"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Frontend attribute of the dockerfile frontend for remote contexts
+	frontendAttrContext = "context"
+	// Limit for fetching http_context to calculate its digest
+	httpContextTimeout = 10 * time.Minute
+)
+
+var (
+	gitContextAttributes = map[string]tfsdk.Attribute{
+		"url": {
+			Type:                types.StringType,
+			MarkdownDescription: "URL of the repository, e.g. `https://github.com/moby/buildkit.git`",
+			Required:            true,
+		},
+		"ref": {
+			Type:        types.StringType,
+			Description: "Branch, tag or commit to build (default: HEAD)",
+			Optional:    true,
+		},
+		"subdir": {
+			Type:        types.StringType,
+			Description: "Directory within the repository used as context",
+			Optional:    true,
+		},
+	}
+)
+
+type gitContext struct {
+	URL    string  `tfsdk:"url"`
+	Ref    *string `tfsdk:"ref"`
+	Subdir *string `tfsdk:"subdir"`
+}
+
+type httpContext struct {
+	URL string `tfsdk:"url"`
+}
+
+// pinnedGitContext formats a git context the way the dockerfile frontend
+// expects it, with the ref replaced by commit.
+func pinnedGitContext(url string, commit string, subdir string) string {
+	if subdir == "" {
+		return fmt.Sprintf("%s#%s", url, commit)
+	}
+	return fmt.Sprintf("%s#%s:%s", url, commit, subdir)
+}
+
+// remoteContext returns the context the build fetches itself, if any.
+// The git ref is resolved in case planning could not do it.
+func (args *builtArguments) remoteContext(ctx context.Context, rc *registry.Client) (string, diag.Diagnostics) {
+	if args.HTTPContext != nil {
+		if args.HTTPDigest.IsUnknown() || args.HTTPDigest.IsNull() {
+			dgst, err := fetchDigest(ctx, rc, args.HTTPContext.URL)
+			if err != nil {
+				return "", diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(path.Root("http_context").AtName("url"), "Fetching http context failed", err.Error()),
+				}
+			}
+			args.HTTPDigest = types.String{Value: dgst.String()}
+		}
+		return args.HTTPContext.URL, nil
+	}
+	if args.GitContext == nil {
+		return "", nil
+	}
+
+	if args.GitCommit.IsUnknown() || args.GitCommit.IsNull() {
+		ref := ""
+		if args.GitContext.Ref != nil {
+			ref = *args.GitContext.Ref
+		}
+		commit, err := git.ResolveRef(ctx, args.GitContext.URL, ref)
+		if err != nil {
+			return "", diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("git_context").AtName("ref"), "Resolving git ref failed", err.Error()),
+			}
+		}
+		args.GitCommit = types.String{Value: commit}
+	}
+
+	subdir := ""
+	if args.GitContext.Subdir != nil {
+		subdir = *args.GitContext.Subdir
+	}
+	return pinnedGitContext(args.GitContext.URL, args.GitCommit.Value, subdir), nil
+}
+
+// planGitCommit resolves the ref of git_context, so that a moved ref shows
+// up as a change.
+func planGitCommit(ctx context.Context, plan tfsdk.Plan) (types.String, diag.Diagnostics) {
+	gc := types.Object{}
+	diags := plan.GetAttribute(ctx, path.Root("git_context"), &gc)
+	if diags.HasError() {
+		return types.String{}, diags
+	}
+	if gc.IsNull() {
+		return types.String{Null: true}, nil
+	}
+	if gc.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+
+	url, _ := gc.Attrs["url"].(types.String)
+	ref, _ := gc.Attrs["ref"].(types.String)
+	if url.IsUnknown() || ref.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+
+	commit, err := git.ResolveRef(ctx, url.Value, ref.Value)
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("git_context").AtName("ref"), "Resolving git ref failed", err.Error()),
+		}
+	}
+	return types.String{Value: commit}, nil
+}
+
+// fetchDigest calculates the digest of the content served at rawURL. The
+// host is reached with the TLS configuration of registries, in case it is
+// configured as one.
+// The digest only serves detecting changes. BuildKit fetches the URL again
+// and does not verify the content against it.
+func fetchDigest(ctx context.Context, rc *registry.Client, rawURL string) (godigest.Digest, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{
+		Transport: rc.HTTPClient(u.Host).Transport,
+		Timeout:   httpContextTimeout,
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("GET %s: %s", rawURL, resp.Status)
+	}
+	return godigest.SHA256.FromReader(resp.Body)
+}
+
+// planHTTPDigest fetches http_context, so that changed content at the same
+// URL shows up as a change.
+func planHTTPDigest(ctx context.Context, rc *registry.Client, plan tfsdk.Plan) (types.String, diag.Diagnostics) {
+	hc := types.Object{}
+	diags := plan.GetAttribute(ctx, path.Root("http_context"), &hc)
+	if diags.HasError() {
+		return types.String{}, diags
+	}
+	if hc.IsNull() {
+		return types.String{Null: true}, nil
+	}
+	if hc.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+
+	url, _ := hc.Attrs["url"].(types.String)
+	if url.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+
+	dgst, err := fetchDigest(ctx, rc, url.Value)
+	if err != nil {
+		return types.String{}, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("http_context").AtName("url"), "Fetching http context failed", err.Error()),
+		}
+	}
+	return types.String{Value: dgst.String()}, nil
+}
+
+// planRemoteContext returns the pinned remote context of plan. Null in case
+// the build uses a local context.
+func planRemoteContext(ctx context.Context, plan tfsdk.Plan) (types.String, diag.Diagnostics) {
+	hc := types.Object{}
+	diags := plan.GetAttribute(ctx, path.Root("http_context"), &hc)
+	if diags.HasError() || hc.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	if !hc.IsNull() {
+		url, _ := hc.Attrs["url"].(types.String)
+		return url, nil
+	}
+
+	gc := types.Object{}
+	diags = plan.GetAttribute(ctx, path.Root("git_context"), &gc)
+	if diags.HasError() || gc.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	if gc.IsNull() {
+		return types.String{Null: true}, nil
+	}
+
+	commit := types.String{}
+	diags = plan.GetAttribute(ctx, path.Root("git_commit"), &commit)
+	if diags.HasError() || commit.IsUnknown() {
+		return types.String{Unknown: true}, diags
+	}
+	url, _ := gc.Attrs["url"].(types.String)
+	subdir, _ := gc.Attrs["subdir"].(types.String)
+	if url.IsUnknown() || subdir.IsUnknown() {
+		return types.String{Unknown: true}, nil
+	}
+	return types.String{Value: pinnedGitContext(url.Value, commit.Value, subdir.Value)}, nil
+}
+
+// validateRemoteContextConfig rejects local contexts when the build fetches
+// its context itself.
+func validateRemoteContextConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	gc := types.Object{}
+	diags := config.GetAttribute(ctx, path.Root("git_context"), &gc)
+	if diags.HasError() {
+		return diags
+	}
+	hc := types.Object{}
+	diags = config.GetAttribute(ctx, path.Root("http_context"), &hc)
+	if diags.HasError() {
+		return diags
+	}
+
+	attr := path.Root("git_context")
+	switch {
+	case !gc.IsNull() && !hc.IsNull():
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("http_context"), "Conflicting build context", "Only one of git_context and http_context can be set"),
+		}
+	case gc.IsNull() && hc.IsNull():
+		return nil
+	case gc.IsNull():
+		attr = path.Root("http_context")
+	}
+
+	def := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() {
+		return diags
+	}
+	if !def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(attr, "Conflicting build definition", "Remote contexts require a frontend and cannot be used with llb_definition"),
+		}
+	}
+
+	contextFiles := types.Map{}
+	diags = config.GetAttribute(ctx, path.Root("context_files"), &contextFiles)
+	if diags.HasError() {
+		return diags
+	}
+	if !contextFiles.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(attr, "Conflicting build context", "Remote contexts cannot be used with context_files"),
+		}
+	}
+
+	localDirs := types.Map{}
+	diags = config.GetAttribute(ctx, path.Root("local_dirs"), &localDirs)
+	if diags.HasError() {
+		return diags
+	}
+	if _, ok := localDirs.Elems[localNameContext]; ok {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs").AtMapKey(localNameContext), "Conflicting build context", "local_dirs[\"context\"] cannot be set together with a remote context"),
+		}
+	}
+	return nil
+}
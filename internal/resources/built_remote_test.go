@@ -0,0 +1,67 @@
+package resources
+
+import (
+	"context"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+func TestFetchDigest(t *testing.T) {
+	content := "context v1"
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/context.tar" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	t.Cleanup(srv.Close)
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	ctx := context.Background()
+	if _, err := fetchDigest(ctx, registry.NewClient(), srv.URL+"/context.tar"); err == nil {
+		t.Fatal("expected the certificate of the stand-in to be rejected")
+	}
+
+	// The certificate is trusted the way registries configure it
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(ca, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	rc, err := registry.NewClientWithHosts(registry.Hosts{
+		host: {RootCAs: []string{ca}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dgst, err := fetchDigest(ctx, rc, srv.URL+"/context.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dgst != godigest.FromString("context v1") {
+		t.Errorf("got %s, want digest of content", dgst)
+	}
+
+	content = "context v2"
+	changed, err := fetchDigest(ctx, rc, srv.URL+"/context.tar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed == dgst {
+		t.Error("expected changed content to change the digest")
+	}
+
+	if _, err := fetchDigest(ctx, rc, srv.URL+"/missing.tar"); err == nil {
+		t.Error("expected an error for a missing tarball")
+	}
+}
@@ -2,24 +2,41 @@ package resources
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
 
-	"github.com/abergmeier/buildkit_ex/pkg/digest"
+	"github.com/abergmeier/terraform-provider-buildkit/internal/validators"
 	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
 	"github.com/hashicorp/terraform-plugin-framework/diag"
 	"github.com/hashicorp/terraform-plugin-framework/path"
 	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
 	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
 	"github.com/hashicorp/terraform-plugin-framework/types"
-	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/cmd/buildctl/build"
 	"github.com/moby/buildkit/session"
-	"github.com/moby/buildkit/session/secrets/secretsprovider"
 	"github.com/moby/buildkit/util/entitlements"
 )
 
+const (
+	// Names of locals used by the dockerfile frontend
+	localNameContext    = "context"
+	localNameDockerfile = "dockerfile"
+
+	// Keys of client.SolveResponse.ExporterResponse
+	exporterImageDigestKey = "containerimage.digest"
+)
+
 var (
 	builtSchema = tfsdk.Schema{
+		MarkdownDescription: "Builds with BuildKit. Pushed images can be imported by reference, e.g. `registry/repo:tag`. The inputs an imported image was built from are unknown, so the first apply after importing always rebuilds it",
 		Attributes: map[string]tfsdk.Attribute{
 			"output": {
 				Type:                types.StringType,
@@ -31,6 +48,58 @@ var (
 				Description: "Path to trace file. Defaults to no tracing.",
 				Optional:    true,
 			},
+			"otlp_trace": {
+				Description: "Export a span per vertex of the build via OTLP, either to a file or to a collector",
+				Optional:    true,
+				Attributes:  tfsdk.SingleNestedAttributes(otlpTraceAttributes),
+			},
+			"context_files": {
+				MarkdownDescription: "Files of the build context held in memory, keyed by path. Merged on top of `local_dirs[\"context\"]` if set, otherwise they are the whole context",
+				Optional:            true,
+				Attributes: tfsdk.MapNestedAttributes(map[string]tfsdk.Attribute{
+					"content": {
+						Type:        types.StringType,
+						Description: "Content of the file",
+						Required:    true,
+					},
+					"mode": {
+						Type:                types.StringType,
+						MarkdownDescription: "Octal file mode, e.g. `0755` (default: `0644`)",
+						Optional:            true,
+					},
+				}),
+			},
+			"dockerfile_inline": {
+				Type:                types.StringType,
+				MarkdownDescription: "Content of the Dockerfile, served to the frontend as the `dockerfile` local. Conflicts with `local_dirs[\"dockerfile\"]`",
+				Optional:            true,
+			},
+			"git_context": {
+				MarkdownDescription: "Build from a Git repository instead of a local context. The ref is resolved to a commit when planning, see `git_commit`",
+				Optional:            true,
+				Attributes:          tfsdk.SingleNestedAttributes(gitContextAttributes),
+			},
+			"git_commit": {
+				Type:        types.StringType,
+				Description: "Commit the ref of git_context resolved to",
+				Computed:    true,
+			},
+			"http_context": {
+				MarkdownDescription: "Build from a tarball fetched over HTTP instead of a local context. The tarball is fetched when planning to detect changes, see `http_digest`",
+				Optional:            true,
+				Attributes: tfsdk.SingleNestedAttributes(map[string]tfsdk.Attribute{
+					"url": {
+						Type:        types.StringType,
+						Description: "URL of the tarball",
+						Required:    true,
+					},
+				}),
+			},
+			"http_digest": {
+				Type:                types.StringType,
+				MarkdownDescription: "Digest of the tarball served at the URL of `http_context` when planning. Only used to detect changes, BuildKit fetches the URL again when building and does not verify the digest",
+				Computed:            true,
+			},
 			"local_dirs": {
 				Type: types.MapType{
 					ElemType: types.StringType,
@@ -38,18 +107,154 @@ var (
 				Description: "Allow build access to the local directory",
 				Optional:    true,
 			},
+			"named_context": {
+				MarkdownDescription: "Named contexts for the dockerfile frontend keyed by name, e.g. to replace `FROM name` or `COPY --from=name`. Exactly one source has to be set per context",
+				Optional:            true,
+				Attributes: tfsdk.MapNestedAttributes(map[string]tfsdk.Attribute{
+					"local": {
+						Type:        types.StringType,
+						Description: "Local directory",
+						Optional:    true,
+					},
+					"docker_image": {
+						Type:                types.StringType,
+						MarkdownDescription: "Image reference, e.g. `docker.io/library/alpine:3.16`. Tags are pinned to the digest they resolve to",
+						Optional:            true,
+					},
+					"git": {
+						Description: "Git repository. The ref is pinned to the commit it resolves to",
+						Optional:    true,
+						Attributes:  tfsdk.SingleNestedAttributes(gitContextAttributes),
+					},
+					"oci_layout": {
+						Description: "Image in a local OCI layout. Requires BuildKit v0.11 or later",
+						Optional:    true,
+						Attributes: tfsdk.SingleNestedAttributes(map[string]tfsdk.Attribute{
+							"path": {
+								Type:        types.StringType,
+								Description: "Directory of the OCI layout",
+								Required:    true,
+							},
+							"tag": {
+								Type:                types.StringType,
+								MarkdownDescription: "Tag of the image within the layout (default: `latest`)",
+								Optional:            true,
+							},
+						}),
+					},
+					"source": {
+						Type:        types.StringType,
+						Description: "Source passed to the frontend, pinned to a commit or digest",
+						Computed:    true,
+					},
+				}),
+			},
 			"oci_layout": {
 				Type: types.ListType{
 					ElemType: types.StringType,
 				},
-				Description: "Allow build access to the local OCI layout",
-				Optional:    true,
+				MarkdownDescription: "Local OCI layouts served to the build as content stores. Format `<store-id>=<path>`, referenced as `oci-layout://<store-id>@<digest>` by named contexts. Requires BuildKit v0.11 or later, older daemons do not query session content stores",
+				Optional:            true,
+			},
+			"base_images": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "Digests of the base images of the Dockerfile, keyed by the reference in `FROM`. Resolved when planning, changes trigger a rebuild",
+				Computed:            true,
+			},
+			"pin_base_images": {
+				Type:                types.BoolType,
+				MarkdownDescription: "Build from the digests in `base_images` instead of resolving the references again, for reproducible builds",
+				Optional:            true,
 			},
 			"frontend": {
 				Type:        types.StringType,
 				Description: "Define frontend used for build",
 				Optional:    true,
 			},
+			"llb_definition": {
+				Type:                types.StringType,
+				MarkdownDescription: "Build a marshalled LLB definition instead of using a frontend. Either a path to a file or base64 encoded protobuf, e.g. `filebase64(\"build.llb\")`",
+				Optional:            true,
+			},
+			"build_args": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				Description: "Build arguments of the dockerfile frontend",
+				Optional:    true,
+			},
+			"sensitive_build_args": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				Description: "Build arguments of the dockerfile frontend, which are redacted from plans and logs",
+				Optional:    true,
+				Sensitive:   true,
+			},
+			"target": {
+				Type:        types.StringType,
+				Description: "Target stage of the dockerfile frontend to build",
+				Optional:    true,
+			},
+			"labels": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				Description: "Labels added to the image by the dockerfile frontend",
+				Optional:    true,
+			},
+			"network": {
+				Type:                types.StringType,
+				MarkdownDescription: "Network mode of `RUN` instructions, one of `default`, `none` or `host`. `host` requires `network.host` in `allow`",
+				Optional:            true,
+				Validators: []tfsdk.AttributeValidator{
+					validators.StringOneOf(networkDefault, networkNone, networkHost),
+				},
+			},
+			"add_hosts": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "Additional entries of `/etc/hosts` in `RUN` instructions, mapping host names to IP addresses",
+				Optional:            true,
+			},
+			"shm_size": {
+				Type:                types.StringType,
+				MarkdownDescription: "Size of `/dev/shm` in `RUN` instructions, e.g. `128m`",
+				Optional:            true,
+			},
+			"ulimits": {
+				MarkdownDescription: "Ulimits of `RUN` instructions keyed by name, e.g. `nofile`",
+				Optional:            true,
+				Attributes: tfsdk.MapNestedAttributes(map[string]tfsdk.Attribute{
+					"soft": {
+						Type:        types.Int64Type,
+						Description: "Soft limit",
+						Required:    true,
+					},
+					"hard": {
+						Type:        types.Int64Type,
+						Description: "Hard limit",
+						Required:    true,
+					},
+				}),
+			},
+			"platforms": {
+				Type: types.ListType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "Target platforms of the build, e.g. `[\"linux/amd64\", \"linux/arm64\"]`. Have to be supported by a worker",
+				Optional:            true,
+			},
+			"platform_digests": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				Description: "Manifest digest per platform of a pushed image, keyed by platform",
+				Computed:    true,
+			},
 			"opts": {
 				Type: types.MapType{
 					ElemType: types.StringType,
@@ -58,6 +263,7 @@ var (
 				Optional:            true,
 			},
 			"cache": {
+				Optional: true,
 				Attributes: tfsdk.SingleNestedAttributes(
 					map[string]tfsdk.Attribute{
 						"disable": {
@@ -66,6 +272,7 @@ var (
 							Optional:    true,
 						},
 						"export": {
+							Optional: true,
 							Attributes: tfsdk.SingleNestedAttributes(
 								map[string]tfsdk.Attribute{
 									"strings": {
@@ -110,14 +317,110 @@ var (
 			},
 			"metadata_file": {
 				Type:        types.StringType,
-				Description: "Output build metadata (e.g., image digest) to a file as JSON",
+				Description: "Output build metadata (e.g., image digest) to a file as JSON, after the state is saved",
+				Optional:    true,
+			},
+			"provenance_export": {
+				Description: "Export the provenance of the build in addition to the provenance attribute",
 				Optional:    true,
+				Attributes:  tfsdk.SingleNestedAttributes(provenanceExportAttributes),
+			},
+			"retry": {
+				Description: "Retry builds failing with transient errors",
+				Optional:    true,
+				Attributes:  tfsdk.SingleNestedAttributes(retryAttributes),
+			},
+			"signing": {
+				Description: "Sign pushed images with a cosign compatible signature",
+				Optional:    true,
+				Attributes:  tfsdk.SingleNestedAttributes(signingAttributes),
+			},
+			"atomic_tags": {
+				Type:                types.BoolType,
+				MarkdownDescription: "Push the image by digest first and point all tags at it afterwards. In case any tag fails, tags written so far are rolled back to their previous digest",
+				Optional:            true,
+			},
+			"on_destroy": {
+				Type:                types.StringType,
+				MarkdownDescription: "What to do with the output when destroying, one of `keep` (default), `delete_tag`, `delete_manifest` for pushed images or `remove_dir` for `local` and `tar` outputs",
+				Optional:            true,
+				Validators: []tfsdk.AttributeValidator{
+					validators.StringOneOf(onDestroyKeep, onDestroyDeleteTag, onDestroyDeleteManifest, onDestroyRemoveDir),
+				},
+			},
+			"triggers": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "Arbitrary values, e.g. the version of an external package, which replace the build when changed, same as the `triggers` of `null_resource`",
+				Optional:            true,
+				PlanModifiers: tfsdk.AttributePlanModifiers{
+					tresource.RequiresReplace(),
+				},
+			},
+			"sensitive_triggers": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "Same as `triggers`, but redacted from plans and logs. The values are still stored in state, marked as sensitive. Only the hashes in `sensitive_trigger_hashes` are compared for replacing the build",
+				Optional:            true,
+				Sensitive:           true,
+			},
+			"sensitive_trigger_hashes": {
+				Type: types.MapType{
+					ElemType: types.StringType,
+				},
+				MarkdownDescription: "SHA-256 digests of the values of `sensitive_triggers`. Changes replace the build",
+				Computed:            true,
+			},
+			"image_digest": {
+				Type:        types.StringType,
+				Description: "Digest of the image manifest or index exported by the build",
+				Computed:    true,
+			},
+			"image_names": {
+				Type: types.ListType{
+					ElemType: types.StringType,
+				},
+				Description: "Image names the build result was exported to",
+				Computed:    true,
+			},
+			"metadata": {
+				Type:                types.StringType,
+				MarkdownDescription: "Build metadata (e.g., image digest) as JSON, use `jsondecode` to access values",
+				Computed:            true,
+			},
+			"provenance": {
+				Type:        types.StringType,
+				Description: "SLSA v0.2 provenance of the build as in-toto statement in JSON",
+				Computed:    true,
+			},
+			"output_digest": {
+				Type:        types.StringType,
+				Description: "Digest of the exported directory tree or tarball of local and tar outputs. Unset when refreshing finds the output modified or deleted. Changes to the output trigger a rebuild",
+				Computed:    true,
+			},
+			"input_digest": {
+				Type:                types.StringType,
+				MarkdownDescription: "Digest of the local inputs of the build, as uploaded. Files excluded by `.dockerignore` are not included. Changes trigger a rebuild. Unset for imported images, which are therefore rebuilt by the first apply",
+				Computed:            true,
+			},
+		},
+		Blocks: map[string]tfsdk.Block{
+			"timeouts": {
+				Description: "Timeouts of building",
+				NestingMode: tfsdk.BlockNestingModeSingle,
+				Attributes:  timeoutsAttributes,
 			},
 		},
 	}
 )
 
 type builtResource struct {
+	client   *client.Client
+	registry *registry.Client
+	stopped  <-chan struct{}
+	builds   *sync.WaitGroup
 }
 
 func NewBuiltResource() tresource.Resource {
@@ -128,147 +431,473 @@ func (r *builtResource) Metadata(context.Context, tresource.MetadataRequest, *tr
 
 }
 
+// Configure picks up the client created by the provider.
+func (r *builtResource) Configure(ctx context.Context, req tresource.ConfigureRequest, resp *tresource.ConfigureResponse) {
+
+	// Provider is not configured yet
+	if req.ProviderData == nil {
+		return
+	}
+
+	data, ok := req.ProviderData.(*ProviderData)
+	if !ok {
+		resp.Diagnostics.AddError("Unexpected provider data", fmt.Sprintf("Expected *ProviderData, got %T", req.ProviderData))
+		return
+	}
+	r.client = data.Client
+	r.registry = data.Registry
+	r.stopped = data.Stopped
+	r.builds = data.Builds
+}
+
+// registryClient returns the registry client configured by the provider,
+// falling back to the default one.
+func (r *builtResource) registryClient() *registry.Client {
+	if r.registry == nil {
+		return registry.NewClient()
+	}
+	return r.registry
+}
+
 func (r *builtResource) GetSchema(context.Context) (tfsdk.Schema, diag.Diagnostics) {
 	return builtSchema, nil
 }
 
 type builtArguments struct {
-	Allow []string `tfsdk:"allow"`
-	Cache struct {
-		Disable       bool     `tfsdk:"disable"`
-		ExportStrings []string `tfsdk:"export_strings"`
-		ImportStrings []string `tfsdk:"import_strings"`
+	AddHosts   map[string]string `tfsdk:"add_hosts"`
+	Allow      []string          `tfsdk:"allow"`
+	AtomicTags *bool             `tfsdk:"atomic_tags"`
+	BuildArgs  map[string]string `tfsdk:"build_args"`
+	Cache      *struct {
+		Disable *bool `tfsdk:"disable"`
+		Export  *struct {
+			Strings []string `tfsdk:"strings"`
+			Opts    []string `tfsdk:"opts"`
+		} `tfsdk:"export"`
+		ImportStrings []string `tfsdk:"import"`
 	} `tfsdk:"cache"`
-	Frontend      string             `tfsdk:"frontend"`
-	Opts          map[string]string  `tfsdk:"opts"`
-	LocalDirs     map[string]string  `tfsdk:"local_dirs"`
-	MetadataFile  *string            `tfsdk:"metadata_file"`
-	OutputStrings []string           `tfsdk:"output_strings"`
-	Secrets       []secretAttachment `tfsdk:"secrets"`
+	ContextFiles       map[string]contextFile  `tfsdk:"context_files"`
+	DockerfileInline   *string                 `tfsdk:"dockerfile_inline"`
+	Frontend           *string                 `tfsdk:"frontend"`
+	GitContext         *gitContext             `tfsdk:"git_context"`
+	HTTPContext        *httpContext            `tfsdk:"http_context"`
+	Labels             map[string]string       `tfsdk:"labels"`
+	LLBDefinition      *string                 `tfsdk:"llb_definition"`
+	LocalDirs          map[string]string       `tfsdk:"local_dirs"`
+	MetadataFile       *string                 `tfsdk:"metadata_file"`
+	NamedContexts      map[string]namedContext `tfsdk:"named_context"`
+	Network            *string                 `tfsdk:"network"`
+	OCILayout          []string                `tfsdk:"oci_layout"`
+	OnDestroy          *string                 `tfsdk:"on_destroy"`
+	Opts               map[string]string       `tfsdk:"opts"`
+	OTLPTrace          *otlpTrace              `tfsdk:"otlp_trace"`
+	Output             *string                 `tfsdk:"output"`
+	PinBaseImages      *bool                   `tfsdk:"pin_base_images"`
+	Platforms          []string                `tfsdk:"platforms"`
+	ProvenanceExport   *provenanceExport       `tfsdk:"provenance_export"`
+	Retry              *retry                  `tfsdk:"retry"`
+	Secrets            []string                `tfsdk:"secret"`
+	SensitiveBuildArgs map[string]string       `tfsdk:"sensitive_build_args"`
+	SensitiveTriggers  map[string]string       `tfsdk:"sensitive_triggers"`
+	ShmSize            *string                 `tfsdk:"shm_size"`
+	Signing            *signingConfig          `tfsdk:"signing"`
+	Target             *string                 `tfsdk:"target"`
+	Timeouts           *timeouts               `tfsdk:"timeouts"`
+	Trace              *string                 `tfsdk:"trace"`
+	Triggers           map[string]string       `tfsdk:"triggers"`
+	Ulimits            map[string]ulimit       `tfsdk:"ulimits"`
+
+	GitCommit    types.String `tfsdk:"git_commit"`
+	HTTPDigest   types.String `tfsdk:"http_digest"`
+	ImageDigest  types.String `tfsdk:"image_digest"`
+	ImageNames   types.List   `tfsdk:"image_names"`
+	InputDigest  types.String `tfsdk:"input_digest"`
+	Metadata     types.String `tfsdk:"metadata"`
+	OutputDigest types.String `tfsdk:"output_digest"`
+	Provenance   types.String `tfsdk:"provenance"`
+
+	BaseImages             types.Map `tfsdk:"base_images"`
+	PlatformDigests        types.Map `tfsdk:"platform_digests"`
+	SensitiveTriggerHashes types.Map `tfsdk:"sensitive_trigger_hashes"`
+}
+
+type contextFile struct {
+	Content string  `tfsdk:"content"`
+	Mode    *string `tfsdk:"mode"`
+}
+
+func (args *builtArguments) outputStrings() []string {
+	if args.Output == nil {
+		return nil
+	}
+	return []string{*args.Output}
+}
+
+// sensitiveValues returns the values to redact from logs.
+func (args *builtArguments) sensitiveValues() []string {
+	values := make([]string, 0, len(args.SensitiveBuildArgs))
+	for _, v := range args.SensitiveBuildArgs {
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+func (args *builtArguments) noCache() bool {
+	return args.Cache != nil && args.Cache.Disable != nil && *args.Cache.Disable
+}
+
+func (args *builtArguments) exportCacheStrings() ([]string, []string) {
+	if args.Cache == nil || args.Cache.Export == nil {
+		return nil, nil
+	}
+	return args.Cache.Export.Strings, args.Cache.Export.Opts
+}
+
+func (args *builtArguments) importCacheStrings() []string {
+	if args.Cache == nil {
+		return nil
+	}
+	return args.Cache.ImportStrings
+}
+
+// setResult fills in the computed attributes from the result of a build.
+func (args *builtArguments) setResult(res *client.SolveResponse) diag.Diagnostics {
+	args.ImageDigest = types.String{Null: true}
+	if dgst, ok := res.ExporterResponse[exporterImageDigestKey]; ok {
+		args.ImageDigest = types.String{Value: dgst}
+	}
+
+	args.ImageNames = types.List{ElemType: types.StringType, Null: true}
+	if args.Output != nil {
+		e, err := parseOutputEntry(*args.Output)
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing output failed", err.Error()),
+			}
+		}
+		if e.Type == client.ExporterImage || e.pushed() {
+			args.ImageNames = stringList(e.imageNames())
+		}
+	}
+
+	metadata, err := buildctl.Metadata(res.ExporterResponse)
+	if err != nil {
+		return diag.Diagnostics{
+			diag.NewErrorDiagnostic("Formatting metadata failed", err.Error()),
+		}
+	}
+	args.Metadata = types.String{Value: string(metadata)}
+
+	if args.GitCommit.IsUnknown() {
+		args.GitCommit = types.String{Null: true}
+	}
+	if args.HTTPDigest.IsUnknown() {
+		args.HTTPDigest = types.String{Null: true}
+	}
+	args.SensitiveTriggerHashes = triggerHashes(args.SensitiveTriggers)
+	if args.InputDigest.IsUnknown() {
+		args.InputDigest = types.String{Null: true}
+	}
+	return nil
 }
 
-type builtAttributes struct {
-	localDigest struct {
-		lastRead [64]byte
-		pushed   [64]byte
+// unsetPendingResults unsets computed attributes, which are still unknown
+// since a step after publishing the result failed. State must not contain
+// unknown values.
+func (args *builtArguments) unsetPendingResults() {
+	if args.OutputDigest.IsUnknown() {
+		args.OutputDigest = types.String{Null: true}
+	}
+	if args.Provenance.IsUnknown() {
+		args.Provenance = types.String{Null: true}
+	}
+	if args.PlatformDigests.IsUnknown() {
+		args.PlatformDigests = types.Map{ElemType: types.StringType, Null: true}
 	}
-	remoteDigest [64]byte
 }
 
-type secretAttachment struct {
-	ID       string `tfsdk:"id"`
-	FilePath string `tfsdk:"file_path"`
-	Env      string `tfsdk:"env"`
+// writeMetadataFile writes the metadata to metadata_file, if set.
+func (args *builtArguments) writeMetadataFile() diag.Diagnostics {
+	if args.MetadataFile == nil || args.Metadata.IsNull() {
+		return nil
+	}
+	if err := buildctl.WriteMetadataFile(*args.MetadataFile, []byte(args.Metadata.Value)); err != nil {
+		return diag.Diagnostics{
+			diag.NewAttributeWarningDiagnostic(path.Root("metadata_file"), "Writing metadata file failed", err.Error()),
+		}
+	}
+	return nil
+}
+
+func stringList(values []string) types.List {
+	elems := make([]attr.Value, 0, len(values))
+	for _, v := range values {
+		elems = append(elems, types.String{Value: v})
+	}
+	return types.List{
+		ElemType: types.StringType,
+		Elems:    elems,
+	}
 }
 
 func (r *builtResource) Create(ctx context.Context, req tresource.CreateRequest, resp *tresource.CreateResponse) {
 
-	var c *client.Client
-	diags := req.ProviderMeta.Get(ctx, &c)
+	args := builtArguments{}
+	diags := req.Plan.Get(ctx, &args)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	args := builtArguments{}
-	diags = req.Plan.Get(ctx, &args)
+	buildCtx, cancel := r.buildContext(ctx, args.Timeouts.create())
+	defer cancel()
+
+	published, diags := r.build(buildCtx, &args)
 	resp.Diagnostics.Append(diags...)
-	if resp.Diagnostics.HasError() {
+	if !published {
 		return
 	}
 
-	ent, diags := parseAllow(args.Allow)
+	diags = resp.State.Set(ctx, &args)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	sa, diags := parseSecrets(args.Secrets)
+	diags = args.writeMetadataFile()
+	resp.Diagnostics.Append(diags...)
+	diags = args.writeProvenanceFile()
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r *builtResource) Read(ctx context.Context, req tresource.ReadRequest, resp *tresource.ReadResponse) {
+
+	args := builtArguments{}
+	diags := req.State.Get(ctx, &args)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	exports, diags := parseOutput(args.OutputStrings)
+	diags = args.refreshOutputDigest(ctx)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	cacheExports, diags := parseExportCache(args.Cache.ExportStrings)
+	diags = resp.State.Set(ctx, &args)
+	resp.Diagnostics.Append(diags...)
+}
+
+func (r *builtResource) Update(ctx context.Context, req tresource.UpdateRequest, resp *tresource.UpdateResponse) {
+
+	args := builtArguments{}
+	diags := req.Plan.Get(ctx, &args)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	cacheImports, diags := parseImportCache(args.Cache.ImportStrings)
+	buildCtx, cancel := r.buildContext(ctx, args.Timeouts.update())
+	defer cancel()
+
+	published, diags := r.build(buildCtx, &args)
+	resp.Diagnostics.Append(diags...)
+	if !published {
+		return
+	}
+
+	diags = resp.State.Set(ctx, &args)
 	resp.Diagnostics.Append(diags...)
 	if resp.Diagnostics.HasError() {
 		return
 	}
 
-	metadataFile := ""
-	if args.MetadataFile != nil {
-		metadataFile = *args.MetadataFile
+	diags = args.writeMetadataFile()
+	resp.Diagnostics.Append(diags...)
+	diags = args.writeProvenanceFile()
+	resp.Diagnostics.Append(diags...)
+}
+
+// build runs the build described by args and fills in the computed
+// attributes of args from the result. published reports whether the result
+// exists and has to be kept in state, even in case of errors.
+func (r *builtResource) build(ctx context.Context, args *builtArguments) (published bool, diags diag.Diagnostics) {
+
+	if r.client == nil {
+		return false, diag.Diagnostics{
+			diag.NewErrorDiagnostic("Unconfigured provider", "The BuildKit client is not configured, make sure the provider is configured before building"),
+		}
+	}
+
+	ent, diags := parseAllow(args.Allow)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	sa, diags := parseSecrets(args.Secrets)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	key, diags := args.signer()
+	if diags.HasError() {
+		return false, diags
+	}
+
+	rc := r.registryClient()
+
+	exports, diags := parseOutput(args.outputStrings())
+	if diags.HasError() {
+		return false, diags
+	}
+	markInsecureRegistries(exports, rc)
+	if args.atomicTags() {
+		pushByDigest(exports)
+	}
+
+	cacheExports, diags := parseExportCache(args.exportCacheStrings())
+	if diags.HasError() {
+		return false, diags
+	}
+
+	cacheImports, diags := parseImportCache(args.importCacheStrings())
+	if diags.HasError() {
+		return false, diags
+	}
+
+	frontend := ""
+	if args.Frontend != nil {
+		frontend = *args.Frontend
+	}
+
+	localDirs, localSources, diags := parseLocals(args)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	frontendAttrs, diags := parseOpts(ctx, rc, args)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	ociStores, namedSources, diags := parseNamedContexts(ctx, rc, args, frontendAttrs, localDirs)
+	if diags.HasError() {
+		return false, diags
+	}
+	localSources = append(localSources, namedSources...)
+
+	diags = args.resolveBaseImages(ctx, rc, frontendAttrs)
+	if diags.HasError() {
+		return false, diags
+	}
+	diags = args.pinBaseImages(frontendAttrs)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	diags = parseOCILayouts(args, ociStores)
+	if diags.HasError() {
+		return false, diags
+	}
+
+	var def []byte
+	if args.LLBDefinition != nil {
+		def, diags = parseLLBDefinition(*args.LLBDefinition)
+		if diags.HasError() {
+			return false, diags
+		}
 	}
 
 	bc := buildctl.BuildConfig{
 		AllowedEntitlements: ent,
 		SecretAttachables:   sa,
+		Definition:          def,
 		Exports:             exports,
 		ExportCaches:        cacheExports,
-		Frontend:            args.Frontend,
+		Frontend:            frontend,
 		ImportCaches:        cacheImports,
-		FrontendAttrs:       parseOpts(args.Opts),
-		LocalDirs:           parseLocal(args.LocalDirs),
-		MetadataFile:        metadataFile,
-		NoCache:             args.Cache.Disable,
+		FrontendAttrs:       frontendAttrs,
+		LocalDirs:           localDirs,
+		LocalSources:        localSources,
+		NoCache:             args.noCache(),
+		OCIStores:           ociStores,
+		OTLP:                args.otlpConfig(),
+		Provenance:          &buildctl.ProvenanceRecorder{},
+		Retry:               args.retryConfig(),
+		SensitiveValues:     args.sensitiveValues(),
+		TracefileName:       args.tracefileName(),
 	}
 
-	err := buildctl.BuildAction(ctx, c, &bc)
+	res, err := buildctl.BuildAction(ctx, r.client, &bc)
+	var interrupted *buildctl.InterruptedError
+	if errors.As(err, &interrupted) {
+		return false, diag.Diagnostics{
+			interruptedDiagnostic(interrupted),
+		}
+	}
+	var failure *buildctl.FailureError
+	if errors.As(err, &failure) {
+		return false, diag.Diagnostics{
+			failureDiagnostic(args, failure),
+		}
+	}
 	if err != nil {
-		resp.Diagnostics.AddError("Building failed", err.Error())
-		return
+		return false, diag.Diagnostics{
+			diag.NewErrorDiagnostic("Building failed", err.Error()),
+		}
 	}
-}
-
-func (r *builtResource) Read(ctx context.Context, req tresource.ReadRequest, resp *tresource.ReadResponse) {
 
-	args := builtArguments{}
-	diags := req.State.Get(ctx, &args)
-	resp.Diagnostics.Append(diags...)
-	if resp.Diagnostics.HasError() {
-		return
+	diags = args.setResult(res)
+	if diags.HasError() {
+		return false, diags
 	}
 
-	dockerfile := ""
-	switch args.Frontend {
-	case "dockerfile.v0":
-		//TODO: Implement finding the Dockerfile in args
-	default:
-		tflog.Warn(ctx, "Input caching not yet implemented", map[string]interface{}{
-			"frontend": args.Frontend,
-		})
+	diags = publishTags(ctx, rc, args)
+	if diags.HasError() {
+		return false, diags
 	}
+	// Failures from here on leave the published result in state, so that
+	// the resource gets tainted instead of the image untracked
+	defer args.unsetPendingResults()
 
-	if dockerfile != "" {
-		digest, err := digest.DigestOfFileAndAllInputs(dockerfile)
-		if err != nil {
-			tflog.Warn(ctx, "Calculating digest failed", map[string]interface{}{
-				"error": err.Error(),
-			})
+	dgst, err := outputDigest(args.Output)
+	if err != nil {
+		return true, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Calculating output digest failed", err.Error()),
 		}
-		_ = digest
-		// TODO: Update local digest
 	}
-}
+	args.OutputDigest = dgst
 
-func (r *builtResource) Update(ctx context.Context, req tresource.UpdateRequest, resp *tresource.UpdateResponse) {
-	// TODO; check wether local vs remote matches
+	args.Provenance, diags = args.provenance(bc.Provenance, frontendAttrs, localNames(localDirs, localSources))
+	if diags.HasError() {
+		return true, diags
+	}
+
+	args.PlatformDigests, diags = platformDigests(ctx, rc, args)
+	if diags.HasError() {
+		return true, diags
+	}
+	diags = pushProvenance(ctx, rc, args, key)
+	if diags.HasError() {
+		return true, diags
+	}
+	return true, signImage(ctx, rc, args, key)
 }
 
-func (r *builtResource) Delete(context.Context, tresource.DeleteRequest, *tresource.DeleteResponse) {
+func (r *builtResource) Delete(ctx context.Context, req tresource.DeleteRequest, resp *tresource.DeleteResponse) {
+
+	args := builtArguments{}
+	diags := req.State.Get(ctx, &args)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
 
+	diags = destroyOutput(ctx, r.registryClient(), &args)
+	resp.Diagnostics.Append(diags...)
 }
 
 func parseAllow(inp []string) ([]entitlements.Entitlement, diag.Diagnostics) {
@@ -285,11 +914,11 @@ func parseAllow(inp []string) ([]entitlements.Entitlement, diag.Diagnostics) {
 	return ent, nil
 }
 
-func parseExportCache(exportCaches []string) ([]client.CacheOptionsEntry, diag.Diagnostics) {
-	cacheExports, err := build.ParseExportCache(exportCaches, nil)
+func parseExportCache(exportCaches []string, legacyExportCacheOpts []string) ([]client.CacheOptionsEntry, diag.Diagnostics) {
+	cacheExports, err := build.ParseExportCache(exportCaches, legacyExportCacheOpts)
 	if err != nil {
 		return nil, diag.Diagnostics{
-			diag.NewAttributeErrorDiagnostic(path.Root("cache").AtName("export_strings"), "Parsing one Export Cache failed", err.Error()),
+			diag.NewAttributeErrorDiagnostic(path.Root("cache").AtName("export"), "Parsing one Export Cache failed", err.Error()),
 		}
 	}
 
@@ -300,37 +929,168 @@ func parseImportCache(importCaches []string) ([]client.CacheOptionsEntry, diag.D
 	cacheImports, err := build.ParseImportCache(importCaches)
 	if err != nil {
 		return nil, diag.Diagnostics{
-			diag.NewAttributeErrorDiagnostic(path.Root("cache").AtName("import_strings"), "Parse one Import Cache failed", err.Error()),
+			diag.NewAttributeErrorDiagnostic(path.Root("cache").AtName("import"), "Parse one Import Cache failed", err.Error()),
 		}
 	}
 
 	return cacheImports, nil
 }
 
-func parseLocal(locals map[string]string) map[string]string {
-	return locals
+// parseLocals splits the locals into directories on disk and sources
+// served with excludes or from memory.
+func parseLocals(args *builtArguments) (map[string]string, []buildctl.SyncedSource, diag.Diagnostics) {
+	var files map[string]buildctl.MemFile
+	if len(args.ContextFiles) != 0 {
+		var diags diag.Diagnostics
+		files, diags = parseContextFiles(args.ContextFiles)
+		if diags.HasError() {
+			return nil, nil, diags
+		}
+	}
+
+	localDirs, sources, err := locals(args.LocalDirs, args.DockerfileInline, files, args.Opts)
+	if err != nil {
+		return nil, nil, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs"), "Reading .dockerignore failed", err.Error()),
+		}
+	}
+	return localDirs, sources, nil
+}
+
+// locals splits the locals into directories on disk and sources. The
+// context is always served as source, so that its .dockerignore applies
+// regardless of the frontend.
+func locals(dirs map[string]string, inline *string, files map[string]buildctl.MemFile, opts map[string]string) (map[string]string, []buildctl.SyncedSource, error) {
+	localDirs := make(map[string]string, len(dirs))
+	for name, dir := range dirs {
+		localDirs[name] = dir
+	}
+	sources := []buildctl.SyncedSource{}
+
+	if inline != nil {
+		sources = append(sources, buildctl.MemSource(localNameDockerfile, map[string]buildctl.MemFile{
+			dockerfileName(opts): {
+				Data: []byte(*inline),
+			},
+		}))
+	}
+
+	var contextSource buildctl.SyncedSource
+	dir, ok := localDirs[localNameContext]
+	switch {
+	case len(files) != 0 && ok:
+		contextSource = buildctl.OverlaySource(localNameContext, dir, files)
+	case len(files) != 0:
+		contextSource = buildctl.MemSource(localNameContext, files)
+	case ok:
+		contextSource = buildctl.DirSource(localNameContext, dir)
+	default:
+		return localDirs, sources, nil
+	}
+	delete(localDirs, localNameContext)
+
+	var dockerfileSource *buildctl.SyncedSource
+	if dir, ok := localDirs[localNameDockerfile]; ok {
+		src := buildctl.DirSource(localNameDockerfile, dir)
+		dockerfileSource = &src
+	} else if inline == nil {
+		// The Dockerfile may be one of the context files
+		src := contextSource
+		src.Name = localNameDockerfile
+		dockerfileSource = &src
+		if len(files) != 0 {
+			sources = append(sources, src)
+		}
+	}
+
+	excludes, err := contextExcludes(contextSource, dockerfileSource, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	contextSource.Excludes = excludes
+	sources = append(sources, contextSource)
+	return localDirs, sources, nil
+}
+
+func parseContextFiles(contextFiles map[string]contextFile) (map[string]buildctl.MemFile, diag.Diagnostics) {
+	files := make(map[string]buildctl.MemFile, len(contextFiles))
+	for p, f := range contextFiles {
+		mode := os.FileMode(0644)
+		if f.Mode != nil {
+			m, err := strconv.ParseUint(*f.Mode, 8, 32)
+			if err != nil || os.FileMode(m)&^os.ModePerm != 0 {
+				return nil, diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(path.Root("context_files").AtMapKey(p).AtName("mode"), "Invalid file mode", fmt.Sprintf("Mode has to be octal permission bits, got %s", *f.Mode)),
+				}
+			}
+			mode = os.FileMode(m)
+		}
+		files[buildctl.CleanMemPath(p)] = buildctl.MemFile{
+			Data: []byte(f.Content),
+			Mode: mode,
+		}
+	}
+	return files, nil
+}
+
+func parseSecrets(sl []string) (session.Attachable, diag.Diagnostics) {
+	sa, err := build.ParseSecret(sl)
+	if err != nil {
+		return nil, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("secret"), "Creating secrets Store failed", err.Error()),
+		}
+	}
+	return sa, nil
 }
 
-func parseSecrets(sl []secretAttachment) (session.Attachable, diag.Diagnostics) {
-	fs := make([]secretsprovider.Source, 0, len(sl))
-	for _, v := range sl {
-		fs = append(fs, secretsprovider.Source{
-			ID:       v.ID,
-			FilePath: v.FilePath,
-			Env:      v.Env,
-		})
+// parseLLBDefinition reads a marshalled LLB definition either from a file
+// or from base64 encoded protobuf.
+func parseLLBDefinition(s string) ([]byte, diag.Diagnostics) {
+	p := path.Root("llb_definition")
+	dt, err := os.ReadFile(s)
+	if err != nil {
+		var derr error
+		dt, derr = base64.StdEncoding.DecodeString(s)
+		if derr != nil {
+			return nil, diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(p, "Reading LLB definition failed", fmt.Sprintf("Neither a readable file (%s) nor base64 (%s)", err, derr)),
+			}
+		}
 	}
-	store, err := secretsprovider.NewStore(fs)
+
+	err = buildctl.ValidateDefinition(dt)
 	if err != nil {
 		return nil, diag.Diagnostics{
-			diag.NewAttributeErrorDiagnostic(path.Root("secrets"), "Creating secrets Store failed", err.Error()),
+			diag.NewAttributeErrorDiagnostic(p, "Invalid LLB definition", err.Error()),
 		}
 	}
-	return secretsprovider.NewSecretProvider(store), nil
+	return dt, nil
 }
 
-func parseOpts(opts map[string]string) map[string]string {
-	return opts
+// parseOpts returns the attributes passed to the frontend. Typed attributes
+// of the dockerfile frontend, platforms and a remote context are passed as
+// attributes as well.
+func parseOpts(ctx context.Context, rc *registry.Client, args *builtArguments) (map[string]string, diag.Diagnostics) {
+	attrs, diags := args.dockerfileAttrs()
+	if diags.HasError() {
+		return nil, diags
+	}
+	for k, v := range args.Opts {
+		attrs[k] = v
+	}
+
+	if len(args.Platforms) != 0 {
+		attrs[frontendAttrPlatform] = strings.Join(args.Platforms, ",")
+	}
+
+	remote, diags := args.remoteContext(ctx, rc)
+	if diags.HasError() {
+		return nil, diags
+	}
+	if remote != "" {
+		attrs[frontendAttrContext] = remote
+	}
+	return attrs, nil
 }
 
 func parseOutput(exports []string) ([]client.ExportEntry, diag.Diagnostics) {
@@ -338,7 +1098,7 @@ func parseOutput(exports []string) ([]client.ExportEntry, diag.Diagnostics) {
 	out, err := build.ParseOutput(exports)
 	if err != nil {
 		return nil, diag.Diagnostics{
-			diag.NewAttributeErrorDiagnostic(path.Root("outputs"), "Parsing one output failed", err.Error()),
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing one output failed", err.Error()),
 		}
 	}
 	return out, nil
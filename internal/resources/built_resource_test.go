@@ -0,0 +1,66 @@
+package resources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+)
+
+// builtRaw creates a value of the schema of builtResource with attrs set
+// and everything else null.
+func builtRaw(t *testing.T, attrs map[string]tftypes.Value) tftypes.Value {
+	t.Helper()
+	typ, ok := builtSchema.Type().TerraformType(context.Background()).(tftypes.Object)
+	if !ok {
+		t.Fatal("schema is not an object")
+	}
+	vals := make(map[string]tftypes.Value, len(typ.AttributeTypes))
+	for name, attrType := range typ.AttributeTypes {
+		vals[name] = tftypes.NewValue(attrType, nil)
+		if v, ok := attrs[name]; ok {
+			vals[name] = v
+		}
+	}
+	for name := range attrs {
+		if _, ok := typ.AttributeTypes[name]; !ok {
+			t.Fatalf("unknown attribute %s", name)
+		}
+	}
+	return tftypes.NewValue(typ, vals)
+}
+
+func TestTimeoutsBlock(t *testing.T) {
+	if _, ok := builtSchema.Blocks["timeouts"]; !ok {
+		t.Fatal("timeouts is not a block")
+	}
+
+	timeoutsType := tftypes.Object{AttributeTypes: map[string]tftypes.Type{
+		"create": tftypes.String,
+		"update": tftypes.String,
+	}}
+	config := tfsdk.Config{
+		Schema: builtSchema,
+		Raw: builtRaw(t, map[string]tftypes.Value{
+			"timeouts": tftypes.NewValue(timeoutsType, map[string]tftypes.Value{
+				"create": tftypes.NewValue(tftypes.String, "30m"),
+				"update": tftypes.NewValue(tftypes.String, "-1m"),
+			}),
+		}),
+	}
+	diags := validateTimeoutsConfig(context.Background(), config)
+	if diags.ErrorsCount() != 1 {
+		t.Fatalf("expected an error for the negative update timeout, got %v", diags)
+	}
+
+	var ts *timeouts
+	diags = config.GetAttribute(context.Background(), path.Root("timeouts"), &ts)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	if ts.create().String() != "30m0s" {
+		t.Errorf("got create timeout %s", ts.create())
+	}
+}
@@ -0,0 +1,104 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/validators"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+const (
+	defaultRetryBackoff    = 5 * time.Second
+	defaultRetryMaxBackoff = time.Minute
+)
+
+var (
+	retryAttributes = map[string]tfsdk.Attribute{
+		"attempts": {
+			Type:        types.Int64Type,
+			Description: "Attempts in total, including the first one",
+			Required:    true,
+		},
+		"backoff": {
+			Type:                types.StringType,
+			MarkdownDescription: "Wait before the first retry, doubled for every further one (default: `5s`)",
+			Optional:            true,
+		},
+		"max_backoff": {
+			Type:                types.StringType,
+			MarkdownDescription: "Maximum wait in between attempts (default: `1m`)",
+			Optional:            true,
+		},
+		"on": {
+			Type: types.ListType{
+				ElemType: types.StringType,
+			},
+			MarkdownDescription: "Classes of transient errors to retry on, any of `registry` (5xx and 429 responses), `network` (e.g. connection resets) and `grpc` (broken streams to buildkitd). Defaults to all. Failing build steps are never retried",
+			Optional:            true,
+			Validators: []tfsdk.AttributeValidator{
+				validators.ListOf(validators.StringOneOf(buildctl.RetryClasses...)),
+			},
+		},
+	}
+)
+
+type retry struct {
+	Attempts   int64    `tfsdk:"attempts"`
+	Backoff    *string  `tfsdk:"backoff"`
+	MaxBackoff *string  `tfsdk:"max_backoff"`
+	On         []string `tfsdk:"on"`
+}
+
+func (args *builtArguments) retryConfig() *buildctl.RetryConfig {
+	r := args.Retry
+	if r == nil {
+		return nil
+	}
+	cfg := &buildctl.RetryConfig{
+		Attempts:   int(r.Attempts),
+		Backoff:    defaultRetryBackoff,
+		MaxBackoff: defaultRetryMaxBackoff,
+		Classes:    r.On,
+	}
+	if r.Backoff != nil {
+		cfg.Backoff = parseDuration(r.Backoff)
+	}
+	if r.MaxBackoff != nil {
+		cfg.MaxBackoff = parseDuration(r.MaxBackoff)
+	}
+	return cfg
+}
+
+func validateRetryConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	r := types.Object{}
+	diags := config.GetAttribute(ctx, path.Root("retry"), &r)
+	if diags.HasError() || r.IsNull() || r.IsUnknown() {
+		return diags
+	}
+
+	attempts, _ := r.Attrs["attempts"].(types.Int64)
+	if !attempts.IsUnknown() && !attempts.IsNull() && attempts.Value < 1 {
+		diags.AddAttributeError(path.Root("retry").AtName("attempts"), "Invalid attempts", "At least one attempt is necessary")
+	}
+
+	for _, attr := range []string{"backoff", "max_backoff"} {
+		s, _ := r.Attrs[attr].(types.String)
+		if s.IsNull() || s.IsUnknown() {
+			continue
+		}
+		d, err := time.ParseDuration(s.Value)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("cannot be negative")
+		}
+		if err != nil {
+			diags.AddAttributeError(path.Root("retry").AtName(attr), "Invalid backoff", fmt.Sprintf("%s: %s", s.Value, err))
+		}
+	}
+	return diags
+}
@@ -0,0 +1,127 @@
+package resources
+
+import (
+	"context"
+	"crypto"
+	"encoding/base64"
+	"fmt"
+	"sort"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/signing"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+var (
+	signingAttributes = map[string]tfsdk.Attribute{
+		"private_key": {
+			Type:        types.StringType,
+			Description: "PEM encoded, unencrypted ECDSA or Ed25519 private key",
+			Required:    true,
+			Sensitive:   true,
+		},
+	}
+)
+
+type signingConfig struct {
+	PrivateKey string `tfsdk:"private_key"`
+}
+
+// signer parses the signing key. Nil if images are not signed.
+func (args *builtArguments) signer() (crypto.Signer, diag.Diagnostics) {
+	if args.Signing == nil {
+		return nil, nil
+	}
+	key, err := signing.ParsePrivateKey([]byte(args.Signing.PrivateKey))
+	if err != nil {
+		return nil, diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("signing").AtName("private_key"), "Invalid signing key", err.Error()),
+		}
+	}
+	return key, nil
+}
+
+// signedDigests lists the pushed image digest and the digests of all its
+// platforms.
+func (args *builtArguments) signedDigests() []godigest.Digest {
+	digests := []godigest.Digest{}
+	if args.ImageDigest.IsNull() || args.ImageDigest.IsUnknown() {
+		return digests
+	}
+	digests = append(digests, godigest.Digest(args.ImageDigest.Value))
+	platforms := make([]string, 0, len(args.PlatformDigests.Elems))
+	for p := range args.PlatformDigests.Elems {
+		platforms = append(platforms, p)
+	}
+	sort.Strings(platforms)
+	for _, p := range platforms {
+		if d, ok := args.PlatformDigests.Elems[p].(types.String); ok && !d.IsNull() && !d.IsUnknown() && d.Value != args.ImageDigest.Value {
+			digests = append(digests, godigest.Digest(d.Value))
+		}
+	}
+	return digests
+}
+
+// signImage uploads a cosign compatible signature for every pushed digest
+// to every repository the image was pushed to.
+func signImage(ctx context.Context, rc *registry.Client, args *builtArguments, key crypto.Signer) diag.Diagnostics {
+	if key == nil {
+		return nil
+	}
+
+	diags := diag.Diagnostics{}
+	for _, repo := range args.imageRepos() {
+		for _, dgst := range args.signedDigests() {
+			payload, err := signing.Payload(repo, dgst)
+			if err != nil {
+				diags.AddError("Creating signature payload failed", err.Error())
+				continue
+			}
+			sig, err := signing.Sign(key, payload)
+			if err != nil {
+				diags.AddAttributeError(path.Root("signing"), "Signing image failed", err.Error())
+				continue
+			}
+			ref := fmt.Sprintf("%s:%s", repo, signing.SignatureTag(dgst))
+			_, err = rc.AppendArtifact(ctx, ref, signing.SimpleSigningMediaType, payload, map[string]string{
+				signing.SignatureAnnotation: base64.StdEncoding.EncodeToString(sig),
+			})
+			if err != nil {
+				diags.AddAttributeError(path.Root("signing"), fmt.Sprintf("Pushing signature to %s failed", ref), err.Error())
+			}
+		}
+	}
+	return diags
+}
+
+// validateSigningConfig checks that the key is usable and that images are
+// pushed.
+func validateSigningConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	key := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("signing").AtName("private_key"), &key)
+	if diags.HasError() || key.IsNull() {
+		return diags
+	}
+	if !key.IsUnknown() {
+		if _, err := signing.ParsePrivateKey([]byte(key.Value)); err != nil {
+			diags.AddAttributeError(path.Root("signing").AtName("private_key"), "Invalid signing key", err.Error())
+		}
+	}
+
+	output := types.String{}
+	diags.Append(config.GetAttribute(ctx, path.Root("output"), &output)...)
+	if diags.HasError() || output.IsUnknown() {
+		return diags
+	}
+	if !output.IsNull() {
+		if e, err := parseOutputEntry(output.Value); err == nil && e.pushed() {
+			return diags
+		}
+	}
+	diags.AddAttributeError(path.Root("signing"), "Image not signable", "Signing requires an output pushing an image")
+	return diags
+}
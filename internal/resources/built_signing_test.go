@@ -0,0 +1,111 @@
+package resources
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/signing"
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestSignImage(t *testing.T) {
+	reg, host, rc := newTestRegistry(t)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := godigest.FromString("index")
+	amd64 := godigest.FromString("amd64")
+	args := &builtArguments{
+		ImageDigest: types.String{Value: index.String()},
+		ImageNames: types.List{
+			ElemType: types.StringType,
+			Elems: []attr.Value{
+				types.String{Value: host + "/test/app:latest"},
+				types.String{Value: host + "/test/app:v1"},
+			},
+		},
+		PlatformDigests: types.Map{
+			ElemType: types.StringType,
+			Elems: map[string]attr.Value{
+				"linux/amd64": types.String{Value: amd64.String()},
+			},
+		},
+	}
+
+	// Signing again must not add further signatures of the same payload
+	for i := 0; i < 2; i++ {
+		diags := signImage(context.Background(), rc, args, key)
+		if diags.HasError() {
+			t.Fatal(diags)
+		}
+	}
+
+	for _, dgst := range []godigest.Digest{index, amd64} {
+		dt, ok := reg.manifest("test/app", signing.SignatureTag(dgst))
+		if !ok {
+			t.Fatalf("no signature pushed for %s", dgst)
+		}
+		var m ocispec.Manifest
+		if err := json.Unmarshal(dt, &m); err != nil {
+			t.Fatal(err)
+		}
+		if m.MediaType != ocispec.MediaTypeImageManifest {
+			t.Errorf("unexpected media type %s", m.MediaType)
+		}
+		if _, ok := reg.blob(m.Config.Digest); !ok {
+			t.Errorf("config of signature of %s not pushed", dgst)
+		}
+		if len(m.Layers) != 1 {
+			t.Fatalf("expected 1 signature of %s, got %d", dgst, len(m.Layers))
+		}
+
+		l := m.Layers[0]
+		if l.MediaType != signing.SimpleSigningMediaType {
+			t.Errorf("unexpected media type %s of signature layer", l.MediaType)
+		}
+		payload, ok := reg.blob(l.Digest)
+		if !ok {
+			t.Fatalf("payload of signature of %s not pushed", dgst)
+		}
+		var p struct {
+			Critical struct {
+				Identity struct {
+					DockerReference string `json:"docker-reference"`
+				} `json:"identity"`
+				Image struct {
+					DockerManifestDigest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}
+		if err := json.Unmarshal(payload, &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Critical.Identity.DockerReference != host+"/test/app" {
+			t.Errorf("unexpected identity %s", p.Critical.Identity.DockerReference)
+		}
+		if p.Critical.Image.DockerManifestDigest != dgst.String() {
+			t.Errorf("unexpected manifest digest %s", p.Critical.Image.DockerManifestDigest)
+		}
+
+		sig, err := base64.StdEncoding.DecodeString(l.Annotations[signing.SignatureAnnotation])
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := sha256.Sum256(payload)
+		if !ecdsa.VerifyASN1(&key.PublicKey, h[:], sig) {
+			t.Errorf("signature of %s does not verify", dgst)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+package resources
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/docker/distribution/reference"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/moby/buildkit/client"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+const (
+	// Exporter attribute pushing the image without any tag
+	exporterPushByDigest = "push-by-digest"
+	// Exporter attribute allowing pushes via plain HTTP or to registries
+	// with untrusted certificates
+	exporterRegistryInsecure = "registry.insecure"
+)
+
+// tagWrite is a tag pointed at the built image, remembering the digest it
+// pointed to before.
+type tagWrite struct {
+	ref      *registry.Reference
+	previous godigest.Digest
+}
+
+func (args *builtArguments) atomicTags() bool {
+	return args.AtomicTags != nil && *args.AtomicTags
+}
+
+// pushByDigest changes pushing exports to push without tags, which are
+// created by publishTags afterwards.
+func pushByDigest(exports []client.ExportEntry) {
+	for _, ex := range exports {
+		e := outputEntry{Type: ex.Type, Attrs: ex.Attrs}
+		if !e.pushed() {
+			continue
+		}
+		// Tags of the same repository push the same manifest
+		repos := []string{}
+		seen := map[string]struct{}{}
+		for _, name := range e.imageNames() {
+			named, err := reference.ParseNormalizedNamed(name)
+			if err != nil {
+				continue
+			}
+			repo := reference.TrimNamed(named).String()
+			if _, ok := seen[repo]; ok {
+				continue
+			}
+			seen[repo] = struct{}{}
+			repos = append(repos, repo)
+		}
+		ex.Attrs["name"] = strings.Join(repos, ",")
+		ex.Attrs[exporterPushByDigest] = "true"
+	}
+}
+
+// markInsecureRegistries allows exports to push to registries, which are
+// configured to be reached via plain HTTP or without verifying certificates.
+func markInsecureRegistries(exports []client.ExportEntry, rc *registry.Client) {
+	for _, ex := range exports {
+		e := outputEntry{Type: ex.Type, Attrs: ex.Attrs}
+		if !e.pushed() {
+			continue
+		}
+		if _, ok := ex.Attrs[exporterRegistryInsecure]; ok {
+			continue
+		}
+		for _, name := range e.imageNames() {
+			if named, err := reference.ParseNormalizedNamed(name); err == nil && rc.Insecure(reference.Domain(named)) {
+				ex.Attrs[exporterRegistryInsecure] = "true"
+				break
+			}
+		}
+	}
+}
+
+// publishTags points all tags of the output at the image pushed by digest.
+// All previous digests are recorded before the first write, so that in case
+// any write fails, all tags written so far are rolled back.
+func publishTags(ctx context.Context, rc *registry.Client, args *builtArguments) diag.Diagnostics {
+	if !args.atomicTags() || args.ImageDigest.IsNull() || args.ImageDigest.IsUnknown() || args.Output == nil {
+		return nil
+	}
+	e, err := parseOutputEntry(*args.Output)
+	if err != nil || !e.pushed() {
+		return nil
+	}
+	dgst := godigest.Digest(args.ImageDigest.Value)
+
+	writes := []tagWrite{}
+	for _, name := range e.imageNames() {
+		ref, err := registry.ParseReference(name)
+		if err != nil {
+			return diag.Diagnostics{
+				diag.NewAttributeErrorDiagnostic(path.Root("output"), "Parsing image name failed", err.Error()),
+			}
+		}
+		if ref.Tag == "" {
+			continue
+		}
+		w := tagWrite{ref: ref}
+		desc, err := rc.Resolve(ctx, ref.String())
+		switch {
+		case err == nil:
+			w.previous = desc.Digest
+		case !registry.IsNotFound(err):
+			return diag.Diagnostics{
+				diag.NewErrorDiagnostic(fmt.Sprintf("Resolving tag %s failed", ref), err.Error()),
+			}
+		}
+		writes = append(writes, w)
+	}
+
+	for i, w := range writes {
+		err := pointTag(ctx, rc, w.ref, dgst)
+		if err == nil {
+			continue
+		}
+		diags := diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("output"), fmt.Sprintf("Tagging %s failed", w.ref), err.Error()),
+		}
+		// The failed write may have taken effect nevertheless
+		for _, written := range writes[:i+1] {
+			if err := rollbackTag(ctx, rc, written); err != nil {
+				diags.AddError(fmt.Sprintf("Rolling back tag %s failed", written.ref), err.Error())
+			}
+		}
+		return diags
+	}
+	return nil
+}
+
+// pointTag points the tag of ref at the manifest dgst of the same repository
+// and checks that the registry resolves the tag to it.
+func pointTag(ctx context.Context, rc *registry.Client, ref *registry.Reference, dgst godigest.Digest) error {
+	repo := reference.TrimNamed(ref.Named).String()
+	desc, dt, err := rc.FetchManifest(ctx, fmt.Sprintf("%s@%s", repo, dgst))
+	if err != nil {
+		return err
+	}
+	if _, err := rc.PushManifest(ctx, ref.String(), desc.MediaType, dt); err != nil {
+		return err
+	}
+	written, err := rc.Resolve(ctx, ref.String())
+	if err != nil {
+		return err
+	}
+	if written.Digest != dgst {
+		return fmt.Errorf("tag resolves to %s instead of %s", written.Digest, dgst)
+	}
+	return nil
+}
+
+// rollbackTag restores the previous digest of a tag, deleting tags which
+// did not exist before.
+func rollbackTag(ctx context.Context, rc *registry.Client, w tagWrite) error {
+	tflog.Warn(ctx, "Rolling back tag", map[string]interface{}{
+		"tag":      w.ref.String(),
+		"previous": w.previous.String(),
+	})
+	if w.previous == "" {
+		err := rc.DeleteTag(ctx, w.ref.String())
+		if registry.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	return pointTag(ctx, rc, w.ref, w.previous)
+}
+
+// validateAtomicTagsConfig checks that atomic tags are only used for pushed
+// images.
+func validateAtomicTagsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	atomic := types.Bool{}
+	diags := config.GetAttribute(ctx, path.Root("atomic_tags"), &atomic)
+	if diags.HasError() || atomic.IsNull() || atomic.IsUnknown() || !atomic.Value {
+		return diags
+	}
+
+	output := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("output"), &output)
+	if diags.HasError() || output.IsUnknown() {
+		return diags
+	}
+	if !output.IsNull() {
+		e, err := parseOutputEntry(output.Value)
+		if err == nil && e.pushed() {
+			if _, ok := e.Attrs[exporterPushByDigest]; ok {
+				return diag.Diagnostics{
+					diag.NewAttributeErrorDiagnostic(path.Root("output"), "Conflicting push mode", "push-by-digest is set by atomic_tags and cannot be part of output"),
+				}
+			}
+			return nil
+		}
+	}
+	return diag.Diagnostics{
+		diag.NewAttributeErrorDiagnostic(path.Root("atomic_tags"), "Tags not publishable", "atomic_tags requires an output pushing an image"),
+	}
+}
@@ -0,0 +1,64 @@
+package resources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestPublishTagsRollback(t *testing.T) {
+	ctx := context.Background()
+	reg, host, rc := newTestRegistry(t)
+
+	previous, err := rc.PushManifest(ctx, host+"/test/app:v1", ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"annotations":{"build":"1"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	built, err := rc.PushManifest(ctx, host+"/test/app:build", ocispec.MediaTypeImageManifest, []byte(`{"schemaVersion":2,"annotations":{"build":"2"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg.failWrites["test/app:v2"] = true
+
+	atomic := true
+	output := `type=image,"name=` + host + `/test/app:v1,` + host + `/test/app:v2",push=true`
+	args := &builtArguments{
+		AtomicTags:  &atomic,
+		ImageDigest: types.String{Value: built.Digest.String()},
+		Output:      &output,
+	}
+	diags := publishTags(ctx, rc, args)
+	if diags.ErrorsCount() != 1 {
+		t.Fatalf("expected tagging v2 to fail, got %v", diags)
+	}
+
+	dt, ok := reg.manifest("test/app", "v1")
+	if !ok {
+		t.Fatal("existing tag v1 was deleted")
+	}
+	if got := godigest.FromBytes(dt); got != previous.Digest {
+		t.Errorf("expected tag v1 to be restored to %s, got %s", previous.Digest, got)
+	}
+	if _, ok := reg.manifest("test/app", "v2"); ok {
+		t.Error("expected new tag v2 to be deleted")
+	}
+
+	// Without failures all tags point at the built image
+	delete(reg.failWrites, "test/app:v2")
+	diags = publishTags(ctx, rc, args)
+	if diags.HasError() {
+		t.Fatal(diags)
+	}
+	for _, tag := range []string{"v1", "v2"} {
+		dt, ok := reg.manifest("test/app", tag)
+		if !ok {
+			t.Fatalf("tag %s not written", tag)
+		}
+		if got := godigest.FromBytes(dt); got != built.Digest {
+			t.Errorf("expected tag %s to point at %s, got %s", tag, built.Digest, got)
+		}
+	}
+}
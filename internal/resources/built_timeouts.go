@@ -0,0 +1,122 @@
+package resources
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	timeoutsAttributes = map[string]tfsdk.Attribute{
+		"create": {
+			Type:                types.StringType,
+			MarkdownDescription: "Timeout of the build when creating, e.g. `30m`. Defaults to no timeout",
+			Optional:            true,
+		},
+		"update": {
+			Type:                types.StringType,
+			MarkdownDescription: "Timeout of the build when updating, e.g. `30m`. Defaults to no timeout",
+			Optional:            true,
+		},
+	}
+)
+
+type timeouts struct {
+	Create *string `tfsdk:"create"`
+	Update *string `tfsdk:"update"`
+}
+
+func (t *timeouts) create() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return parseDuration(t.Create)
+}
+
+func (t *timeouts) update() time.Duration {
+	if t == nil {
+		return 0
+	}
+	return parseDuration(t.Update)
+}
+
+// parseDuration returns 0 for unset values. Invalid values are rejected when
+// validating the config.
+func parseDuration(s *string) time.Duration {
+	if s == nil {
+		return 0
+	}
+	d, err := time.ParseDuration(*s)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// buildContext derives the context of a build from ctx. It is cancelled
+// after timeout, if any, and when the provider is stopped. The build counts
+// as running until cancel is called.
+func (r *builtResource) buildContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	if r.builds != nil {
+		r.builds.Add(1)
+		var once sync.Once
+		cancelCtx := cancel
+		cancel = func() {
+			cancelCtx()
+			once.Do(r.builds.Done)
+		}
+	}
+
+	go func() {
+		select {
+		case <-r.stopped:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+func interruptedDiagnostic(err *buildctl.InterruptedError) diag.Diagnostic {
+	summary := "Building cancelled"
+	if errors.Is(err.Err, context.DeadlineExceeded) {
+		summary = "Building timed out"
+	}
+	return diag.NewErrorDiagnostic(summary, fmt.Sprintf("The build was stopped after %s.", err.Summary))
+}
+
+func validateTimeoutsConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	t := types.Object{}
+	diags := config.GetAttribute(ctx, path.Root("timeouts"), &t)
+	if diags.HasError() || t.IsNull() || t.IsUnknown() {
+		return diags
+	}
+	for _, attr := range []string{"create", "update"} {
+		s, _ := t.Attrs[attr].(types.String)
+		if s.IsNull() || s.IsUnknown() {
+			continue
+		}
+		d, err := time.ParseDuration(s.Value)
+		if err == nil && d <= 0 {
+			err = fmt.Errorf("has to be positive")
+		}
+		if err != nil {
+			diags.AddAttributeError(path.Root("timeouts").AtName(attr), "Invalid timeout", fmt.Sprintf("%s: %s", s.Value, err))
+		}
+	}
+	return diags
+}
@@ -0,0 +1,113 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/abergmeier/terraform-provider-buildkit/internal/validators"
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+var (
+	otlpTraceAttributes = map[string]tfsdk.Attribute{
+		"file": {
+			Type:        types.StringType,
+			Description: "File to append spans to as OTLP JSON, one export request per line",
+			Optional:    true,
+		},
+		"endpoint": {
+			Type:                types.StringType,
+			MarkdownDescription: "Endpoint of an OTLP collector, e.g. `localhost:4317`",
+			Optional:            true,
+		},
+		"protocol": {
+			Type:                types.StringType,
+			MarkdownDescription: "Protocol of the endpoint, one of `grpc` (default) or `http/protobuf`",
+			Optional:            true,
+			Validators: []tfsdk.AttributeValidator{
+				validators.StringOneOf(buildctl.OTLPProtocolGRPC, buildctl.OTLPProtocolHTTP),
+			},
+		},
+		"insecure": {
+			Type:        types.BoolType,
+			Description: "Connect to the endpoint without TLS",
+			Optional:    true,
+		},
+		"headers": {
+			Type: types.MapType{
+				ElemType: types.StringType,
+			},
+			Description: "Headers sent to the endpoint, e.g. for authentication",
+			Optional:    true,
+			Sensitive:   true,
+		},
+	}
+)
+
+type otlpTrace struct {
+	File     *string           `tfsdk:"file"`
+	Endpoint *string           `tfsdk:"endpoint"`
+	Protocol *string           `tfsdk:"protocol"`
+	Insecure *bool             `tfsdk:"insecure"`
+	Headers  map[string]string `tfsdk:"headers"`
+}
+
+func (args *builtArguments) tracefileName() string {
+	if args.Trace == nil {
+		return ""
+	}
+	return *args.Trace
+}
+
+func (args *builtArguments) otlpConfig() *buildctl.OTLPConfig {
+	t := args.OTLPTrace
+	if t == nil {
+		return nil
+	}
+	cfg := &buildctl.OTLPConfig{
+		Headers: t.Headers,
+	}
+	if t.File != nil {
+		cfg.File = *t.File
+	}
+	if t.Endpoint != nil {
+		cfg.Endpoint = *t.Endpoint
+	}
+	if t.Protocol != nil {
+		cfg.Protocol = *t.Protocol
+	}
+	if t.Insecure != nil {
+		cfg.Insecure = *t.Insecure
+	}
+	return cfg
+}
+
+// validateOTLPTraceConfig checks that spans are exported to exactly one of a
+// file and an endpoint.
+func validateOTLPTraceConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	t := types.Object{}
+	diags := config.GetAttribute(ctx, path.Root("otlp_trace"), &t)
+	if diags.HasError() || t.IsNull() || t.IsUnknown() {
+		return diags
+	}
+
+	file, _ := t.Attrs["file"].(types.String)
+	endpoint, _ := t.Attrs["endpoint"].(types.String)
+	if file.IsNull() == endpoint.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("otlp_trace"), "Invalid trace export", "Exactly one of file and endpoint has to be set"),
+		}
+	}
+	if file.IsNull() {
+		return nil
+	}
+	for _, attr := range []string{"protocol", "insecure", "headers"} {
+		if a, ok := t.Attrs[attr]; ok && !a.IsNull() {
+			diags.AddAttributeError(path.Root("otlp_trace").AtName(attr), "Invalid trace export", attr+" only applies to an endpoint")
+		}
+	}
+	return diags
+}
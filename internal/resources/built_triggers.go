@@ -0,0 +1,60 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/attr"
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// triggerHashes hashes the values of sensitive triggers, so that changes
+// can be detected by comparing hashes.
+func triggerHashes(triggers map[string]string) types.Map {
+	if triggers == nil {
+		return types.Map{ElemType: types.StringType, Null: true}
+	}
+	hashes := types.Map{ElemType: types.StringType, Elems: make(map[string]attr.Value, len(triggers))}
+	for k, v := range triggers {
+		hashes.Elems[k] = types.String{Value: godigest.SHA256.FromString(v).String()}
+	}
+	return hashes
+}
+
+// planSensitiveTriggerHashes hashes the sensitive triggers of the plan and
+// replaces the build in case the hashes differ from the ones in state.
+func planSensitiveTriggerHashes(ctx context.Context, req tresource.ModifyPlanRequest, resp *tresource.ModifyPlanResponse) diag.Diagnostics {
+	v := types.Map{}
+	diags := resp.Plan.GetAttribute(ctx, path.Root("sensitive_triggers"), &v)
+	if diags.HasError() {
+		return diags
+	}
+	triggers, known, diags := knownStringMap(ctx, resp.Plan, path.Root("sensitive_triggers"))
+	if diags.HasError() {
+		return diags
+	}
+	hashes := types.Map{ElemType: types.StringType, Unknown: true}
+	switch {
+	case v.IsNull():
+		hashes = triggerHashes(nil)
+	case known:
+		hashes = triggerHashes(triggers)
+	}
+	diags = resp.Plan.SetAttribute(ctx, path.Root("sensitive_trigger_hashes"), hashes)
+	if diags.HasError() || req.State.Raw.IsNull() {
+		return diags
+	}
+
+	prior := types.Map{ElemType: types.StringType}
+	diags = req.State.GetAttribute(ctx, path.Root("sensitive_trigger_hashes"), &prior)
+	if diags.HasError() {
+		return diags
+	}
+	if !prior.Equal(hashes) {
+		resp.RequiresReplace = append(resp.RequiresReplace, path.Root("sensitive_trigger_hashes"))
+	}
+	return nil
+}
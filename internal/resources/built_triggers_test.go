@@ -0,0 +1,93 @@
+package resources
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+	"github.com/hashicorp/terraform-plugin-go/tftypes"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+func TestPlanSensitiveTriggerHashes(t *testing.T) {
+	stateHashes := stringMapValue(map[string]string{
+		"token": godigest.SHA256.FromString("v1").String(),
+	})
+	tests := map[string]struct {
+		triggers tftypes.Value
+		// Null for creating
+		hashes  tftypes.Value
+		unknown bool
+		replace bool
+	}{
+		"unchanged": {
+			triggers: stringMapValue(map[string]string{"token": "v1"}),
+			hashes:   stateHashes,
+		},
+		"changed": {
+			triggers: stringMapValue(map[string]string{"token": "v2"}),
+			hashes:   stateHashes,
+			replace:  true,
+		},
+		"removed": {
+			triggers: tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, nil),
+			hashes:   stateHashes,
+			replace:  true,
+		},
+		"unknown value": {
+			triggers: tftypes.NewValue(tftypes.Map{ElementType: tftypes.String}, map[string]tftypes.Value{
+				"token": tftypes.NewValue(tftypes.String, tftypes.UnknownValue),
+			}),
+			hashes:  stateHashes,
+			unknown: true,
+			replace: true,
+		},
+		"creating": {
+			triggers: stringMapValue(map[string]string{"token": "v1"}),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			req := tresource.ModifyPlanRequest{
+				State: tfsdk.State{
+					Schema: builtSchema,
+					Raw:    tftypes.NewValue(builtSchema.Type().TerraformType(ctx), nil),
+				},
+			}
+			if !tt.hashes.IsNull() {
+				req.State.Raw = builtRaw(t, map[string]tftypes.Value{
+					"sensitive_trigger_hashes": tt.hashes,
+				})
+			}
+			resp := &tresource.ModifyPlanResponse{
+				Plan: tfsdk.Plan{
+					Schema: builtSchema,
+					Raw: builtRaw(t, map[string]tftypes.Value{
+						"sensitive_triggers": tt.triggers,
+					}),
+				},
+			}
+			diags := planSensitiveTriggerHashes(ctx, req, resp)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+
+			hashes := types.Map{ElemType: types.StringType}
+			diags = resp.Plan.GetAttribute(ctx, path.Root("sensitive_trigger_hashes"), &hashes)
+			if diags.HasError() {
+				t.Fatal(diags)
+			}
+			if hashes.IsUnknown() != tt.unknown {
+				t.Errorf("got hashes %s", hashes)
+			}
+			if replace := len(resp.RequiresReplace) != 0; replace != tt.replace {
+				t.Errorf("got replace %t, want %t", replace, tt.replace)
+			}
+		})
+	}
+}
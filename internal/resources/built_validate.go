@@ -0,0 +1,124 @@
+package resources
+
+import (
+	"context"
+
+	"github.com/hashicorp/terraform-plugin-framework/diag"
+	"github.com/hashicorp/terraform-plugin-framework/path"
+	tresource "github.com/hashicorp/terraform-plugin-framework/resource"
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func (r *builtResource) ValidateConfig(ctx context.Context, req tresource.ValidateConfigRequest, resp *tresource.ValidateConfigResponse) {
+
+	diags := validateOnDestroyConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateLLBDefinitionConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateDockerfileInlineConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateRemoteContextConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateNamedContextsConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateOCILayoutConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validatePlatformsConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateDockerfileAttrsConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateDockerfileConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateProvenanceExportConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateAtomicTagsConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateSigningConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateOTLPTraceConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateTimeoutsConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+
+	diags = validateRetryConfig(ctx, req.Config)
+	resp.Diagnostics.Append(diags...)
+}
+
+// validateLLBDefinitionConfig checks that exactly one of frontend and
+// llb_definition is set and that the definition is valid LLB.
+func validateLLBDefinitionConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	frontend := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("frontend"), &frontend)
+	if diags.HasError() {
+		return diags
+	}
+	def := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() {
+		return diags
+	}
+
+	if !frontend.IsNull() && !def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("llb_definition"), "Conflicting build definition", "Only one of frontend and llb_definition can be set"),
+		}
+	}
+	if frontend.IsNull() && def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("frontend"), "Missing build definition", "One of frontend and llb_definition has to be set"),
+		}
+	}
+	if def.IsNull() || def.IsUnknown() {
+		return nil
+	}
+
+	_, diags = parseLLBDefinition(def.Value)
+	return diags
+}
+
+// validateDockerfileInlineConfig rejects other sources of the Dockerfile
+// when dockerfile_inline is set.
+func validateDockerfileInlineConfig(ctx context.Context, config tfsdk.Config) diag.Diagnostics {
+	inline := types.String{}
+	diags := config.GetAttribute(ctx, path.Root("dockerfile_inline"), &inline)
+	if diags.HasError() || inline.IsNull() {
+		return diags
+	}
+
+	def := types.String{}
+	diags = config.GetAttribute(ctx, path.Root("llb_definition"), &def)
+	if diags.HasError() {
+		return diags
+	}
+	if !def.IsNull() {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("dockerfile_inline"), "Conflicting build definition", "dockerfile_inline requires a frontend and cannot be used with llb_definition"),
+		}
+	}
+
+	localDirs := types.Map{}
+	diags = config.GetAttribute(ctx, path.Root("local_dirs"), &localDirs)
+	if diags.HasError() {
+		return diags
+	}
+	if _, ok := localDirs.Elems[localNameDockerfile]; ok {
+		return diag.Diagnostics{
+			diag.NewAttributeErrorDiagnostic(path.Root("local_dirs").AtMapKey(localNameDockerfile), "Conflicting Dockerfile", "local_dirs[\"dockerfile\"] cannot be set together with dockerfile_inline"),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package resources
+
+import (
+	"sync"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	"github.com/moby/buildkit/client"
+)
+
+// ProviderData is what the provider hands to resources when configured.
+type ProviderData struct {
+	Client *client.Client
+	// Registry reaches registries as configured for the provider
+	Registry *registry.Client
+	// Stopped is closed when the provider is asked to stop, e.g. on SIGINT.
+	// Running builds are cancelled then.
+	Stopped <-chan struct{}
+	// Builds tracks running builds, so that the client is only closed once
+	// they are cancelled
+	Builds *sync.WaitGroup
+}
@@ -0,0 +1,147 @@
+package resources
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/abergmeier/terraform-provider-buildkit/pkg/registry"
+	godigest "github.com/opencontainers/go-digest"
+)
+
+// testRegistry is an in-memory stand-in of the distribution API, covering
+// what the registry client uses.
+type testRegistry struct {
+	mu sync.Mutex
+	// Manifests by repository and tag or digest
+	manifests map[string]map[string]testManifest
+	blobs     map[godigest.Digest][]byte
+	uploads   int
+	// Requests in the form `<method> <path>`
+	requests []string
+	// Manifests PUT by `<repository>:<tag>`, which are written but answered
+	// with an error
+	failWrites map[string]bool
+}
+
+type testManifest struct {
+	mediaType string
+	data      []byte
+}
+
+// newTestRegistry starts a registry stand-in and returns its host together
+// with a client talking plain HTTP to it.
+func newTestRegistry(t *testing.T) (*testRegistry, string, *registry.Client) {
+	t.Helper()
+	reg := &testRegistry{
+		manifests:  map[string]map[string]testManifest{},
+		blobs:      map[godigest.Digest][]byte{},
+		failWrites: map[string]bool{},
+	}
+	srv := httptest.NewServer(reg)
+	t.Cleanup(srv.Close)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	rc, err := registry.NewClientWithHosts(registry.Hosts{
+		host: {PlainHTTP: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reg, host, rc
+}
+
+func (reg *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.requests = append(reg.requests, req.Method+" "+req.URL.Path)
+
+	p := strings.TrimPrefix(req.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(p, "/manifests/"):
+		i := strings.LastIndex(p, "/manifests/")
+		reg.serveManifest(w, req, p[:i], p[i+len("/manifests/"):])
+	case strings.HasSuffix(p, "/blobs/uploads/"):
+		reg.uploads++
+		w.Header().Set("Location", fmt.Sprintf("/v2/%supload-%d", p, reg.uploads))
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(p, "/blobs/uploads/"):
+		dt, _ := io.ReadAll(req.Body)
+		dgst := godigest.Digest(req.URL.Query().Get("digest"))
+		if dgst != godigest.FromBytes(dt) {
+			http.Error(w, "digest mismatch", http.StatusBadRequest)
+			return
+		}
+		reg.blobs[dgst] = dt
+		w.WriteHeader(http.StatusCreated)
+	case strings.Contains(p, "/blobs/"):
+		dgst := godigest.Digest(p[strings.LastIndex(p, "/")+1:])
+		dt, ok := reg.blobs[dgst]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		if req.Method == http.MethodGet {
+			w.Write(dt)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (reg *testRegistry) serveManifest(w http.ResponseWriter, req *http.Request, repo string, object string) {
+	switch req.Method {
+	case http.MethodPut:
+		dt, _ := io.ReadAll(req.Body)
+		m := testManifest{mediaType: req.Header.Get("Content-Type"), data: dt}
+		if reg.manifests[repo] == nil {
+			reg.manifests[repo] = map[string]testManifest{}
+		}
+		reg.manifests[repo][object] = m
+		reg.manifests[repo][godigest.FromBytes(dt).String()] = m
+		if reg.failWrites[repo+":"+object] {
+			http.Error(w, "write failed", http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodDelete:
+		if _, ok := reg.manifests[repo][object]; !ok {
+			http.NotFound(w, req)
+			return
+		}
+		delete(reg.manifests[repo], object)
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodGet, http.MethodHead:
+		m, ok := reg.manifests[repo][object]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", godigest.FromBytes(m.data).String())
+		if req.Method == http.MethodGet {
+			w.Write(m.data)
+		}
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// manifest returns the manifest of repo at object, which is a tag or digest.
+func (reg *testRegistry) manifest(repo string, object string) ([]byte, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	m, ok := reg.manifests[repo][object]
+	return m.data, ok
+}
+
+func (reg *testRegistry) blob(dgst godigest.Digest) ([]byte, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	dt, ok := reg.blobs[dgst]
+	return dt, ok
+}
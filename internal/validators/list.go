@@ -0,0 +1,44 @@
+package validators
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+// ListOf applies the validator elem to every element of a list.
+func ListOf(elem tfsdk.AttributeValidator) tfsdk.AttributeValidator {
+	return &listOf{
+		elem: elem,
+	}
+}
+
+type listOf struct {
+	elem tfsdk.AttributeValidator
+}
+
+func (v *listOf) Description(ctx context.Context) string {
+	return fmt.Sprintf("Every element: %s", v.elem.Description(ctx))
+}
+
+func (v *listOf) MarkdownDescription(ctx context.Context) string {
+	return fmt.Sprintf("Every element: %s", v.elem.MarkdownDescription(ctx))
+}
+
+func (v *listOf) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	l := types.List{}
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &l)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+
+	for i, e := range l.Elems {
+		elemReq := req
+		elemReq.AttributePath = req.AttributePath.AtListIndex(i)
+		elemReq.AttributeConfig = e
+		v.elem.Validate(ctx, elemReq, resp)
+	}
+}
@@ -0,0 +1,48 @@
+package validators
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform-plugin-framework/tfsdk"
+	"github.com/hashicorp/terraform-plugin-framework/types"
+)
+
+func StringOneOf(values ...string) tfsdk.AttributeValidator {
+	return &stringOneOf{
+		values: values,
+	}
+}
+
+type stringOneOf struct {
+	values []string
+}
+
+func (v *stringOneOf) Description(context.Context) string {
+	return fmt.Sprintf("Value must be one of: %s", strings.Join(v.values, ", "))
+}
+
+func (v *stringOneOf) MarkdownDescription(ctx context.Context) string {
+	return fmt.Sprintf("Value must be one of: `%s`", strings.Join(v.values, "`, `"))
+}
+
+func (v *stringOneOf) Validate(ctx context.Context, req tfsdk.ValidateAttributeRequest, resp *tfsdk.ValidateAttributeResponse) {
+	s := types.String{}
+	diags := tfsdk.ValueAs(ctx, req.AttributeConfig, &s)
+	resp.Diagnostics.Append(diags...)
+	if resp.Diagnostics.HasError() {
+		return
+	}
+	if s.IsNull() || s.IsUnknown() {
+		return
+	}
+
+	for _, value := range v.values {
+		if s.Value == value {
+			return
+		}
+	}
+
+	resp.Diagnostics.AddAttributeError(req.AttributePath, "Invalid value", fmt.Sprintf("%s, got: %s", v.Description(ctx), s.Value))
+}
@@ -1,13 +1,16 @@
 package buildctl
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"encoding/json"
 	"io"
 	"os"
+	"time"
 
 	"github.com/containerd/continuity"
+	"github.com/hashicorp/terraform-plugin-log/tflog"
 	"github.com/moby/buildkit/client"
 	"github.com/moby/buildkit/client/llb"
 	"github.com/moby/buildkit/session"
@@ -23,17 +26,23 @@ import (
 
 type BuildConfig struct {
 	AllowedEntitlements []entitlements.Entitlement
+	Definition          []byte
 	ExportCaches        []client.CacheOptionsEntry
 	Frontend            string
 	FrontendAttrs       map[string]string
 	ImportCaches        []client.CacheOptionsEntry
 	LocalDirs           map[string]string
-	MetadataFile        string
+	LocalSources        []SyncedSource
 	NoCache             bool
+	OTLP                *OTLPConfig
+	OCIStores           map[string]string
+	Provenance          *ProvenanceRecorder
 	Exports             []client.ExportEntry
 	ProgressMode        string
+	Retry               *RetryConfig
 	TracefileName       string
 	SecretAttachables   session.Attachable
+	SensitiveValues     []string
 }
 
 func read(r io.Reader, cfg *BuildConfig) (*llb.Definition, error) {
@@ -41,12 +50,12 @@ func read(r io.Reader, cfg *BuildConfig) (*llb.Definition, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse input")
 	}
-	if cfg.NoCache {
-		for _, dt := range def.Def {
-			var op pb.Op
-			if err := (&op).Unmarshal(dt); err != nil {
-				return nil, errors.Wrap(err, "failed to parse llb proto op")
-			}
+	for _, dt := range def.Def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse llb proto op")
+		}
+		if cfg.NoCache {
 			dgst := digest.FromBytes(dt)
 			opMetadata, ok := def.Metadata[dgst]
 			if !ok {
@@ -60,6 +69,13 @@ func read(r io.Reader, cfg *BuildConfig) (*llb.Definition, error) {
 	return def, nil
 }
 
+// ValidateDefinition checks that dt is a marshalled LLB definition
+// consisting of valid operations.
+func ValidateDefinition(dt []byte) error {
+	_, err := read(bytes.NewReader(dt), &BuildConfig{})
+	return err
+}
+
 func openTraceFile(cfg *BuildConfig) (*os.File, error) {
 	if traceFileName := cfg.TracefileName; traceFileName != "" {
 		return os.OpenFile(traceFileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
@@ -69,11 +85,11 @@ func openTraceFile(cfg *BuildConfig) (*os.File, error) {
 
 // BuildAction implements building based on Buildkit code.
 // Most parsing does however already happen outside of BuildAction
-func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error {
+func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) (*client.SolveResponse, error) {
 
 	traceFile, err := openTraceFile(cfg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	var traceEnc *json.Encoder
 	if traceFile != nil {
@@ -91,11 +107,12 @@ func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error
 	cacheExports := cfg.ExportCaches
 	cacheImports := cfg.ImportCaches
 
+	parentCtx := ctx
 	eg, ctx := errgroup.WithContext(ctx)
 
 	solveOpt := client.SolveOpt{
 		Exports: cfg.Exports,
-		// LocalDirs is set later
+		// LocalDirs are served via Session
 		Frontend: cfg.Frontend,
 		// FrontendAttrs is set later
 		CacheExports:        cacheExports,
@@ -106,18 +123,53 @@ func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error
 
 	solveOpt.FrontendAttrs = cfg.FrontendAttrs
 
-	solveOpt.LocalDirs = cfg.LocalDirs
+	// LocalDirs are served by our own provider, since only a single
+	// filesync provider can be attached to a session
+	sources, err := syncedSources(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if len(sources) != 0 {
+		solveOpt.Session = append(solveOpt.Session, NewFSSyncProvider(sources))
+	}
+
+	if len(cfg.OCIStores) != 0 {
+		stores, err := ociStoresAttachable(cfg.OCIStores)
+		if err != nil {
+			return nil, err
+		}
+		solveOpt.Session = append(solveOpt.Session, stores)
+	}
 
 	var def *llb.Definition
+	if len(cfg.Definition) != 0 {
+		def, err = read(bytes.NewReader(cfg.Definition), cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	if cfg.NoCache {
+	if cfg.NoCache && def == nil {
+		if solveOpt.FrontendAttrs == nil {
+			solveOpt.FrontendAttrs = map[string]string{}
+		}
 		solveOpt.FrontendAttrs["no-cache"] = ""
 	}
 
-	// not using shared context to not disrupt display but let is finish reporting errors
-	pw, err := progresswriter.NewPrinter(context.TODO(), os.Stderr, cfg.ProgressMode)
-	if err != nil {
-		return err
+	// Progress is logged, printing is opt-in
+	lw := NewLogWriter(ctx, cfg.SensitiveValues)
+	pw := lw
+	if cfg.ProgressMode != "" {
+		// not using errgroup context to not disrupt display but let is finish reporting errors
+		printer, err := progresswriter.NewPrinter(parentCtx, os.Stderr, cfg.ProgressMode)
+		if err != nil {
+			return nil, err
+		}
+		pw = progresswriter.Tee(printer, lw.Status())
+		eg.Go(func() error {
+			<-lw.Done()
+			return nil
+		})
 	}
 
 	if traceEnc != nil {
@@ -132,6 +184,41 @@ func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error
 			return nil
 		})
 	}
+
+	tracker := newProgressTracker(cfg.SensitiveValues)
+	trackerCh := make(chan *client.SolveStatus)
+	pw = progresswriter.Tee(pw, trackerCh)
+	eg.Go(func() error {
+		tracker.write(trackerCh)
+		return nil
+	})
+
+	// Error of the build, recorded on the span of the build
+	var solveErr error
+	if cfg.OTLP != nil {
+		spans, err := newSpanWriter(ctx, cfg.OTLP, cfg.SensitiveValues)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			// The build context might be cancelled already, so flushing
+			// gets its own deadline
+			closeCtx, cancel := context.WithTimeout(context.Background(), spanCloseTimeout)
+			defer cancel()
+			// Traces are best effort, so failing to export does not fail the build
+			if serr := spans.close(closeCtx, solveErr); serr != nil {
+				tflog.Warn(parentCtx, "Exporting build spans failed", map[string]interface{}{
+					"error": serr.Error(),
+				})
+			}
+		}()
+		spanCh := make(chan *client.SolveStatus)
+		pw = progresswriter.Tee(pw, spanCh)
+		eg.Go(func() error {
+			spans.write(spanCh)
+			return nil
+		})
+	}
 	mw := progresswriter.NewMultiWriter(pw)
 
 	var writers []progresswriter.Writer
@@ -147,27 +234,35 @@ func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error
 		}
 	}
 
+	retry := cfg.Retry
+	if retry != nil && streamsOutput(cfg.Exports) {
+		tflog.Warn(ctx, "Retrying is not supported for outputs written to a file")
+		retry = nil
+	}
+	if retry != nil {
+		// Keeps progress open in between attempts
+		writers = append(writers, mw.WithPrefix("", false))
+	}
+
+	started := time.Now()
+	var resp *client.SolveResponse
 	eg.Go(func() error {
 		defer func() {
 			for _, w := range writers {
 				close(w.Status())
 			}
 		}()
-		resp, err := c.Solve(ctx, def, solveOpt, progresswriter.ResetTime(mw.WithPrefix("", false)).Status())
+		var err error
+		resp, err = solveWithRetry(ctx, retry, func() (*client.SolveResponse, error) {
+			// Every Solve closes its status channel
+			return c.Solve(ctx, def, solveOpt, progresswriter.ResetTime(mw.WithPrefix("", false)).Status())
+		})
 		if err != nil {
 			return err
 		}
 		for k, v := range resp.ExporterResponse {
 			logrus.Debugf("exporter response: %s=%s", k, v)
 		}
-
-		metadataFile := cfg.MetadataFile
-		if metadataFile != "" && resp.ExporterResponse != nil {
-			if err := writeMetadataFile(metadataFile, resp.ExporterResponse); err != nil {
-				return err
-			}
-		}
-
 		return nil
 	})
 
@@ -176,11 +271,68 @@ func BuildAction(ctx context.Context, c *client.Client, cfg *BuildConfig) error
 		return pw.Err()
 	})
 
-	return eg.Wait()
+	err = eg.Wait()
+	solveErr = err
+	if err != nil {
+		// Solves are cancelled via context, which also closes the session
+		if parentCtx.Err() != nil {
+			return nil, &InterruptedError{
+				Summary: tracker.summary(),
+				Err:     parentCtx.Err(),
+			}
+		}
+		src := errorSource(err)
+		if f := tracker.failure(err); f != nil {
+			f.Source = src
+			if f.Source == nil {
+				f.Source = definitionSource(def, f.Digest)
+			}
+			return nil, f
+		}
+		if src != nil {
+			// e.g. the frontend failing to parse the Dockerfile
+			return nil, &FailureError{Source: src, Err: err, masked: cfg.SensitiveValues}
+		}
+		return nil, err
+	}
+	if cfg.Provenance != nil {
+		// Vertexes of the tracker have sensitive values masked already
+		cfg.Provenance.record(started, time.Now(), tracker.vertexList(), def)
+	}
+	return resp, nil
+}
+
+// streamsOutput checks whether any of exports writes to a file opened before
+// building. Such files are closed by the first Solve.
+func streamsOutput(exports []client.ExportEntry) bool {
+	for _, e := range exports {
+		if e.Output != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func syncedSources(cfg *BuildConfig) ([]SyncedSource, error) {
+	if err := checkDirs(cfg.LocalDirs); err != nil {
+		return nil, err
+	}
+	sources := make([]SyncedSource, 0, len(cfg.LocalDirs)+len(cfg.LocalSources))
+	for name, dir := range cfg.LocalDirs {
+		sources = append(sources, DirSource(name, dir))
+	}
+	for _, src := range cfg.LocalSources {
+		if _, ok := cfg.LocalDirs[src.Name]; ok {
+			return nil, errors.Errorf("local %s is both a directory and a source", src.Name)
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
 }
 
-func writeMetadataFile(filename string, exporterResponse map[string]string) error {
-	var err error
+// Metadata formats the exporter response of a build as JSON. Values holding
+// base64 encoded JSON objects are decoded.
+func Metadata(exporterResponse map[string]string) ([]byte, error) {
 	out := make(map[string]interface{})
 	for k, v := range exporterResponse {
 		dt, err := base64.StdEncoding.DecodeString(v)
@@ -195,9 +347,11 @@ func writeMetadataFile(filename string, exporterResponse map[string]string) erro
 		}
 		out[k] = json.RawMessage(dt)
 	}
-	b, err := json.MarshalIndent(out, "", "  ")
-	if err != nil {
-		return err
-	}
-	return continuity.AtomicWriteFile(filename, b, 0666)
+	return json.MarshalIndent(out, "", "  ")
+}
+
+// WriteMetadataFile atomically writes metadata as formatted by Metadata to
+// filename.
+func WriteMetadataFile(filename string, metadata []byte) error {
+	return continuity.AtomicWriteFile(filename, metadata, 0666)
 }
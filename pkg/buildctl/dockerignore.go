@@ -0,0 +1,33 @@
+package buildctl
+
+import (
+	"os"
+
+	"github.com/moby/buildkit/frontend/dockerfile/dockerignore"
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
+)
+
+const DockerignoreFilename = ".dockerignore"
+
+// ReadDockerignore reads the exclude patterns from the ignore file filename
+// served by src. ok is false in case src has no such file.
+func ReadDockerignore(src SyncedSource, filename string) (excludes []string, ok bool, err error) {
+	fs := src.FS(&fsutil.WalkOpt{
+		IncludePatterns: []string{filename},
+	})
+	rc, err := fs.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer rc.Close()
+
+	excludes, err = dockerignore.ReadAll(rc)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "reading %s of %s failed", filename, src.Name)
+	}
+	return excludes, true, nil
+}
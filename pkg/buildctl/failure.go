@@ -0,0 +1,249 @@
+package buildctl
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend/dockerfile/parser"
+	"github.com/moby/buildkit/solver/errdefs"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// Log lines kept for every vertex
+	failureLogLines = 20
+	// Lines shown around the failing source lines
+	excerptContext = 2
+)
+
+// vertexPrefix matches the step prefix of vertexes of the dockerfile
+// frontend, e.g. `[build 2/5] ` or `[2/5] ` for a single stage.
+var vertexPrefix = regexp.MustCompile(`^\[(?:([^\]]*)\s+)?\d+/\d+\]\s+`)
+
+// SourceLocation is a range of lines in a source file of a build.
+type SourceLocation struct {
+	Filename string
+	Data     []byte
+	// 1-based and inclusive
+	StartLine int
+	EndLine   int
+}
+
+func (l *SourceLocation) String() string {
+	if l.StartLine == l.EndLine {
+		return fmt.Sprintf("%s:%d", l.Filename, l.StartLine)
+	}
+	return fmt.Sprintf("%s:%d-%d", l.Filename, l.StartLine, l.EndLine)
+}
+
+// Excerpt renders the lines of l with some context, marking the lines of l.
+func (l *SourceLocation) Excerpt() string {
+	lines := strings.Split(strings.TrimSuffix(string(l.Data), "\n"), "\n")
+	start := l.StartLine - excerptContext
+	if start < 1 {
+		start = 1
+	}
+	end := l.EndLine + excerptContext
+	if end > len(lines) {
+		end = len(lines)
+	}
+
+	sb := &strings.Builder{}
+	sep := strings.Repeat("-", 20) + "\n"
+	fmt.Fprintf(sb, "%s\n%s", l, sep)
+	width := len(fmt.Sprint(end))
+	for i := start; i <= end; i++ {
+		marker := "   "
+		if i >= l.StartLine && i <= l.EndLine {
+			marker = ">>>"
+		}
+		fmt.Fprintf(sb, " %*d | %s %s\n", width, i, marker, lines[i-1])
+	}
+	sb.WriteString(sep)
+	return sb.String()
+}
+
+// FailureError is returned by BuildAction in case a vertex of the build
+// failed.
+type FailureError struct {
+	Vertex string
+	Digest digest.Digest
+	// Last lines logged by the vertex
+	Logs []string
+	// Nil if the vertex cannot be mapped to a source
+	Source *SourceLocation
+	Err    error
+
+	masked masker
+}
+
+func (e *FailureError) Error() string {
+	return e.masked.mask(e.Err.Error())
+}
+
+func (e *FailureError) Unwrap() error {
+	return e.Err
+}
+
+// Detail describes the failure including the source excerpt and logs.
+func (e *FailureError) Detail() string {
+	sb := &strings.Builder{}
+	if e.Source != nil {
+		sb.WriteString(e.Source.Excerpt())
+	}
+	if len(e.Logs) != 0 {
+		fmt.Fprintf(sb, "Last log lines of %s:\n", e.Vertex)
+		for _, l := range e.Logs {
+			fmt.Fprintf(sb, "  %s\n", l)
+		}
+		sb.WriteString("\n")
+	}
+	sb.WriteString(e.Error())
+	return sb.String()
+}
+
+// errorSource returns the source location attached to err by the solver or
+// frontend, if any.
+func errorSource(err error) *SourceLocation {
+	for _, src := range errdefs.Sources(err) {
+		if src.Info == nil {
+			continue
+		}
+		if l := sourceLocation(src.Info, src.Ranges); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// definitionSource maps a vertex of an LLB definition to the source
+// locations attached to it.
+func definitionSource(def *llb.Definition, dgst digest.Digest) *SourceLocation {
+	if def == nil || def.Source == nil {
+		return nil
+	}
+	locs, ok := def.Source.Locations[dgst.String()]
+	if !ok {
+		return nil
+	}
+	for _, loc := range locs.Locations {
+		if int(loc.SourceIndex) >= len(def.Source.Infos) {
+			continue
+		}
+		if l := sourceLocation(def.Source.Infos[loc.SourceIndex], loc.Ranges); l != nil {
+			return l
+		}
+	}
+	return nil
+}
+
+// sourceLocation spans all ranges within info. Nil without ranges.
+func sourceLocation(info *pb.SourceInfo, ranges []*pb.Range) *SourceLocation {
+	if len(ranges) == 0 {
+		return nil
+	}
+	l := &SourceLocation{
+		Filename:  info.Filename,
+		Data:      info.Data,
+		StartLine: int(ranges[0].Start.Line),
+		EndLine:   int(ranges[0].End.Line),
+	}
+	for _, r := range ranges[1:] {
+		if int(r.Start.Line) < l.StartLine {
+			l.StartLine = int(r.Start.Line)
+		}
+		if int(r.End.Line) > l.EndLine {
+			l.EndLine = int(r.End.Line)
+		}
+	}
+	if l.EndLine < l.StartLine {
+		l.EndLine = l.StartLine
+	}
+	return l
+}
+
+// DockerfileSource maps a vertex of the dockerfile frontend to the
+// instruction of the Dockerfile dt it was created by. Vertexes are matched
+// by their name, which contains the stage and the instruction. Only a
+// fallback for errors without source locations, see errorSource. Returns nil
+// if no instruction matches unambiguously.
+func DockerfileSource(filename string, dt []byte, vertex string) *SourceLocation {
+	m := vertexPrefix.FindStringSubmatch(vertex)
+	if m == nil {
+		return nil
+	}
+	// The prefix may contain the platform before the stage name
+	stage := ""
+	if fields := strings.Fields(m[1]); len(fields) != 0 && !strings.Contains(fields[len(fields)-1], "/") {
+		stage = fields[len(fields)-1]
+	}
+	instruction := strings.Fields(vertex[len(m[0]):])
+	if len(instruction) == 0 {
+		return nil
+	}
+
+	res, err := parser.Parse(bytes.NewReader(dt))
+	if err != nil {
+		return nil
+	}
+
+	var candidates []*parser.Node
+	current := ""
+	stages := 0
+	for _, n := range res.AST.Children {
+		if strings.EqualFold(n.Value, "from") {
+			current = stageName(n, stages)
+			stages++
+		}
+		if current != stage && stage != "" {
+			continue
+		}
+		if strings.EqualFold(n.Value, instruction[0]) {
+			candidates = append(candidates, n)
+		}
+	}
+
+	match := func(n *parser.Node) *SourceLocation {
+		return &SourceLocation{
+			Filename:  filename,
+			Data:      dt,
+			StartLine: n.StartLine,
+			EndLine:   n.EndLine,
+		}
+	}
+	if len(candidates) == 1 {
+		return match(candidates[0])
+	}
+	// Arguments differ in case variables got expanded
+	want := strings.Join(instruction[1:], " ")
+	var found *parser.Node
+	for _, n := range candidates {
+		fields := strings.Fields(n.Original)
+		if len(fields) == 0 || strings.Join(fields[1:], " ") != want {
+			continue
+		}
+		if found != nil {
+			return nil
+		}
+		found = n
+	}
+	if found == nil {
+		return nil
+	}
+	return match(found)
+}
+
+// stageName returns the name given to the stage started by the FROM
+// instruction n, as used in vertex names. Unnamed stages are named after
+// their index.
+func stageName(n *parser.Node, index int) string {
+	fields := strings.Fields(n.Original)
+	if len(fields) < 4 || !strings.EqualFold(fields[len(fields)-2], "as") {
+		return fmt.Sprintf("stage-%d", index)
+	}
+	return strings.ToLower(fields[len(fields)-1])
+}
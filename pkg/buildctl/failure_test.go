@@ -0,0 +1,158 @@
+package buildctl
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/solver/errdefs"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+)
+
+const failureDockerfile = `FROM alpine
+RUN apk add make
+
+FROM alpine AS build
+RUN make
+
+FROM scratch
+COPY --from=build /out /
+RUN make
+`
+
+func TestDockerfileSource(t *testing.T) {
+	tests := map[string]struct {
+		vertex string
+		line   int
+	}{
+		"named stage": {
+			vertex: "[build 2/2] RUN make",
+			line:   5,
+		},
+		"unnamed stage": {
+			vertex: "[stage-0 2/2] RUN apk add make",
+			line:   2,
+		},
+		"unnamed stage by index": {
+			vertex: "[stage-2 3/3] RUN make",
+			line:   9,
+		},
+		"platform": {
+			vertex: "[linux/amd64 build 2/2] RUN make",
+			line:   5,
+		},
+		"padded step": {
+			vertex: "[stage-2  3/10] COPY --from=build /out /",
+			line:   8,
+		},
+		"ambiguous": {
+			vertex: "[linux/amd64 2/2] RUN make",
+		},
+		"internal": {
+			vertex: "[internal] load metadata for docker.io/library/alpine:latest",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			l := DockerfileSource("Dockerfile", []byte(failureDockerfile), tt.vertex)
+			if tt.line == 0 {
+				if l != nil {
+					t.Fatalf("expected no match, got %s", l)
+				}
+				return
+			}
+			if l == nil {
+				t.Fatal("expected a match")
+			}
+			if l.StartLine != tt.line || l.EndLine != tt.line {
+				t.Errorf("got %s, want line %d", l, tt.line)
+			}
+		})
+	}
+}
+
+func TestDockerfileSourceSingleStage(t *testing.T) {
+	dt := []byte("FROM alpine\nRUN true\nRUN make\n")
+	l := DockerfileSource("Dockerfile", dt, "[3/3] RUN make")
+	if l == nil || l.StartLine != 3 {
+		t.Fatalf("got %v, want line 3", l)
+	}
+}
+
+func TestErrorSource(t *testing.T) {
+	if l := errorSource(errors.New("failed")); l != nil {
+		t.Fatalf("expected no source, got %s", l)
+	}
+
+	err := errdefs.WithSource(errors.New("failed"), errdefs.Source{
+		Info: &pb.SourceInfo{
+			Filename: "Dockerfile",
+			Data:     []byte(failureDockerfile),
+		},
+		Ranges: []*pb.Range{
+			{Start: pb.Position{Line: 5}, End: pb.Position{Line: 5}},
+		},
+	})
+	l := errorSource(err)
+	if l == nil {
+		t.Fatal("expected a source")
+	}
+	if l.String() != "Dockerfile:5" {
+		t.Errorf("got %s, want Dockerfile:5", l)
+	}
+}
+
+func TestProgressTrackerMasksSensitiveValues(t *testing.T) {
+	tracker := newProgressTracker([]string{"s3cr3t"})
+
+	now := time.Now()
+	dgst := digest.FromString("a")
+	v := &client.Vertex{
+		Digest:    dgst,
+		Name:      "[1/1] RUN login --token=s3cr3t",
+		Started:   &now,
+		Completed: &now,
+		Error:     "login --token=s3cr3t failed",
+	}
+	ch := make(chan *client.SolveStatus, 2)
+	ch <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{v},
+		Logs: []*client.VertexLog{
+			{Vertex: dgst, Data: []byte("token s3")},
+		},
+	}
+	ch <- &client.SolveStatus{
+		Logs: []*client.VertexLog{
+			{Vertex: dgst, Data: []byte("cr3t rejected\n")},
+		},
+	}
+	close(ch)
+	tracker.write(ch)
+
+	if v.Name != "[1/1] RUN login --token=s3cr3t" {
+		t.Errorf("vertex shared with other writers was modified")
+	}
+	for _, v := range tracker.vertexList() {
+		if strings.Contains(v.Name, "s3cr3t") || strings.Contains(v.Error, "s3cr3t") {
+			t.Errorf("sensitive value in vertex %q: %q", v.Name, v.Error)
+		}
+	}
+
+	f := tracker.failure(errors.New("process \"login --token=s3cr3t\" did not complete successfully"))
+	if f == nil {
+		t.Fatal("expected a failure")
+	}
+	if f.Vertex != "[1/1] RUN login --token=***" {
+		t.Errorf("got vertex %q", f.Vertex)
+	}
+	if detail := f.Detail(); strings.Contains(detail, "s3cr3t") {
+		t.Errorf("sensitive value in detail:\n%s", detail)
+	}
+	if strings.Contains(f.Error(), "s3cr3t") {
+		t.Errorf("sensitive value in error %q", f.Error())
+	}
+}
@@ -0,0 +1,126 @@
+package buildctl
+
+import (
+	"os"
+
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/session/filesync"
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Metadata keys of filesync requests, see filesync.FSSync
+const (
+	keyOverrideExcludes = "override-excludes"
+	keyIncludePatterns  = "include-patterns"
+	keyExcludePatterns  = "exclude-patterns"
+	keyFollowPaths      = "followpaths"
+	keyDirName          = "dir-name"
+)
+
+// SyncedSource is a local source the build can request by Name.
+// FS is called for every request with the walk options of that request.
+type SyncedSource struct {
+	Name     string
+	Excludes []string
+	FS       func(opt *fsutil.WalkOpt) fsutil.FS
+}
+
+// DirSource serves a directory on disk, same as client.SolveOpt.LocalDirs.
+func DirSource(name string, dir string) SyncedSource {
+	return SyncedSource{
+		Name: name,
+		FS: func(opt *fsutil.WalkOpt) fsutil.FS {
+			opt.Map = resetUIDAndGID
+			return fsutil.NewFS(dir, opt)
+		},
+	}
+}
+
+// MemSource serves files from memory. Keys of files are slash separated
+// paths relative to the root of the source.
+func MemSource(name string, files map[string]MemFile) SyncedSource {
+	return SyncedSource{
+		Name: name,
+		FS: func(opt *fsutil.WalkOpt) fsutil.FS {
+			return NewMemFS(files, opt)
+		},
+	}
+}
+
+func resetUIDAndGID(p string, st *fstypes.Stat) bool {
+	st.Uid = 0
+	st.Gid = 0
+	return true
+}
+
+type fsSyncProvider struct {
+	sources map[string]SyncedSource
+}
+
+// NewFSSyncProvider creates a filesync provider serving sources. Contrary
+// to filesync.NewFSSyncProvider sources are not restricted to directories
+// on disk.
+func NewFSSyncProvider(sources []SyncedSource) session.Attachable {
+	p := &fsSyncProvider{
+		sources: map[string]SyncedSource{},
+	}
+	for _, s := range sources {
+		p.sources[s.Name] = s
+	}
+	return p
+}
+
+func (sp *fsSyncProvider) Register(server *grpc.Server) {
+	filesync.RegisterFileSyncServer(server, sp)
+}
+
+func (sp *fsSyncProvider) DiffCopy(stream filesync.FileSync_DiffCopyServer) error {
+	opts, _ := metadata.FromIncomingContext(stream.Context()) // if no metadata continue with empty object
+
+	name := ""
+	if v := opts[keyDirName]; len(v) > 0 {
+		name = v[0]
+	}
+
+	src, ok := sp.sources[name]
+	if !ok {
+		return status.Errorf(codes.NotFound, "no access allowed to dir %q", name)
+	}
+
+	excludes := opts[keyExcludePatterns]
+	if len(src.Excludes) != 0 && (len(opts[keyOverrideExcludes]) == 0 || opts[keyOverrideExcludes][0] != "true") {
+		excludes = src.Excludes
+	}
+
+	fs := src.FS(&fsutil.WalkOpt{
+		ExcludePatterns: excludes,
+		IncludePatterns: opts[keyIncludePatterns],
+		FollowPaths:     opts[keyFollowPaths],
+	})
+	return errors.WithStack(fsutil.Send(stream.Context(), stream, fs, nil))
+}
+
+func (sp *fsSyncProvider) TarStream(stream filesync.FileSync_TarStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "tarstream not supported")
+}
+
+// checkDirs fails early for directories that do not exist, same as
+// client.Solve does for LocalDirs.
+func checkDirs(dirs map[string]string) error {
+	for _, d := range dirs {
+		fi, err := os.Stat(d)
+		if err != nil {
+			return errors.Wrapf(err, "could not find %s", d)
+		}
+		if !fi.IsDir() {
+			return errors.Errorf("%s not a directory", d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package buildctl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/fileutils"
+	"github.com/pkg/errors"
+	"github.com/tonistiigi/fsutil"
+	fstypes "github.com/tonistiigi/fsutil/types"
+)
+
+// MemFile is the content of a file served from memory.
+type MemFile struct {
+	Data []byte
+	Mode os.FileMode
+}
+
+type memFS struct {
+	files map[string]MemFile
+	opt   *fsutil.WalkOpt
+}
+
+// NewMemFS creates a fsutil.FS from files. Parent directories are created
+// implicitly. Include and exclude patterns of opt are honored.
+func NewMemFS(files map[string]MemFile, opt *fsutil.WalkOpt) fsutil.FS {
+	cleaned := make(map[string]MemFile, len(files))
+	for p, f := range files {
+		cleaned[CleanMemPath(p)] = f
+	}
+	return &memFS{
+		files: cleaned,
+		opt:   opt,
+	}
+}
+
+// CleanMemPath normalizes p to the form used as keys by NewMemFS.
+func CleanMemPath(p string) string {
+	return strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+}
+
+func (fs *memFS) Walk(ctx context.Context, fn filepath.WalkFunc) error {
+	included, err := fs.included()
+	if err != nil {
+		return err
+	}
+
+	stats := make([]*fstypes.Stat, 0, len(included))
+	dirs := map[string]struct{}{}
+	for _, p := range included {
+		f := fs.files[p]
+		mode := f.Mode
+		if mode == 0 {
+			mode = 0644
+		}
+		stats = append(stats, &fstypes.Stat{
+			Path:  p,
+			Mode:  uint32(mode.Perm()),
+			Size_: int64(len(f.Data)),
+		})
+		for d := path.Dir(p); d != "."; d = path.Dir(d) {
+			if _, ok := dirs[d]; ok {
+				break
+			}
+			dirs[d] = struct{}{}
+			stats = append(stats, &fstypes.Stat{
+				Path: d,
+				Mode: uint32(os.ModeDir | 0755),
+			})
+		}
+	}
+
+	// Walking has to happen in the order the receiver validates
+	sort.Slice(stats, func(i, j int) bool {
+		return fsutil.ComparePath(stats[i].Path, stats[j].Path) < 0
+	})
+
+	for _, st := range stats {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+		if fs.opt != nil && fs.opt.Map != nil && !fs.opt.Map(st.Path, st) {
+			continue
+		}
+		if err := fn(filepath.FromSlash(st.Path), &fsutil.StatInfo{Stat: st}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// included returns the paths of all files matching the walk options.
+func (fs *memFS) included() ([]string, error) {
+	var includes, excludes []string
+	if fs.opt != nil {
+		includes = append(includes, fs.opt.IncludePatterns...)
+		includes = append(includes, fs.opt.FollowPaths...)
+		excludes = fs.opt.ExcludePatterns
+	}
+
+	var excludeMatcher *fileutils.PatternMatcher
+	if len(excludes) != 0 {
+		var err error
+		excludeMatcher, err = fileutils.NewPatternMatcher(excludes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid excludepatterns: %s", excludes)
+		}
+	}
+
+	included := make([]string, 0, len(fs.files))
+	for p := range fs.files {
+		if len(includes) != 0 {
+			ok, err := fileutils.MatchesOrParentMatches(p, includes)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid includepatterns: %s", includes)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if excludeMatcher != nil {
+			ok, err := excludeMatcher.MatchesOrParentMatches(p)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				continue
+			}
+		}
+		included = append(included, p)
+	}
+	return included, nil
+}
+
+func (fs *memFS) Open(p string) (io.ReadCloser, error) {
+	f, ok := fs.files[CleanMemPath(p)]
+	if !ok {
+		return nil, errors.WithStack(&os.PathError{Path: p, Err: syscall.ENOENT, Op: "open"})
+	}
+	return io.NopCloser(bytes.NewReader(f.Data)), nil
+}
+
+type overlayFS struct {
+	lower fsutil.FS
+	upper *memFS
+}
+
+// OverlaySource serves files from memory on top of a directory on disk.
+func OverlaySource(name string, dir string, files map[string]MemFile) SyncedSource {
+	return SyncedSource{
+		Name: name,
+		FS: func(opt *fsutil.WalkOpt) fsutil.FS {
+			lowerOpt := *opt
+			lowerOpt.Map = resetUIDAndGID
+			return &overlayFS{
+				lower: fsutil.NewFS(dir, &lowerOpt),
+				upper: NewMemFS(files, opt).(*memFS),
+			}
+		},
+	}
+}
+
+func (fs *overlayFS) Walk(ctx context.Context, fn filepath.WalkFunc) error {
+	stats := map[string]*fstypes.Stat{}
+	err := fs.lower.Walk(ctx, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st, ok := fi.Sys().(*fstypes.Stat)
+		if !ok {
+			return errors.WithStack(&os.PathError{Path: p, Err: syscall.EBADMSG, Op: "fileinfo without stat info"})
+		}
+		stats[st.Path] = st
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = fs.upper.Walk(ctx, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		st := fi.Sys().(*fstypes.Stat)
+		lower, ok := stats[st.Path]
+		if !ok {
+			stats[st.Path] = st
+			return nil
+		}
+		if fi.IsDir() != os.FileMode(lower.Mode).IsDir() {
+			return errors.Errorf("%s conflicts with file type on disk", st.Path)
+		}
+		if !fi.IsDir() {
+			stats[st.Path] = st
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(stats))
+	for p := range stats {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		return fsutil.ComparePath(paths[i], paths[j]) < 0
+	})
+	for _, p := range paths {
+		if err := fn(filepath.FromSlash(p), &fsutil.StatInfo{Stat: stats[p]}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fs *overlayFS) Open(p string) (io.ReadCloser, error) {
+	if _, ok := fs.upper.files[CleanMemPath(p)]; ok {
+		return fs.upper.Open(p)
+	}
+	return fs.lower.Open(p)
+}
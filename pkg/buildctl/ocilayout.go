@@ -0,0 +1,48 @@
+package buildctl
+
+import (
+	"path/filepath"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/content/local"
+	"github.com/moby/buildkit/client/ociindex"
+	"github.com/moby/buildkit/session"
+	sessioncontent "github.com/moby/buildkit/session/content"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Prefix of content store IDs the daemon uses for OCI layouts
+	ociStorePrefix = "oci:"
+)
+
+// ResolveOCILayout finds the digest of the manifest tagged tag in the OCI
+// layout at dir.
+func ResolveOCILayout(dir string, tag string) (digest.Digest, error) {
+	idx, err := ociindex.ReadIndexJSONFileLocked(filepath.Join(dir, "index.json"))
+	if err != nil {
+		return "", err
+	}
+	for _, m := range idx.Manifests {
+		if m.Annotations[ocispec.AnnotationRefName] == tag {
+			return m.Digest, nil
+		}
+	}
+	return "", errors.Errorf("tag %s not found in OCI layout %s", tag, dir)
+}
+
+// ociStoresAttachable serves the OCI layouts in stores, keyed by store ID,
+// as content stores of the session.
+func ociStoresAttachable(stores map[string]string) (session.Attachable, error) {
+	contentStores := make(map[string]content.Store, len(stores))
+	for id, dir := range stores {
+		cs, err := local.NewStore(dir)
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening OCI layout %s failed", dir)
+		}
+		contentStores[ociStorePrefix+id] = cs
+	}
+	return sessioncontent.NewAttachable(contentStores), nil
+}
@@ -0,0 +1,192 @@
+package buildctl
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+
+	tracerName  = "github.com/abergmeier/terraform-provider-buildkit/pkg/buildctl"
+	serviceName = "terraform-provider-buildkit"
+
+	// Upper bound for flushing spans after the build
+	spanCloseTimeout = 10 * time.Second
+)
+
+// OTLPConfig configures where spans of build vertexes are exported to.
+// Either File or Endpoint has to be set.
+type OTLPConfig struct {
+	// File to append spans to as OTLP JSON, one export request per line
+	File     string
+	Endpoint string
+	// Protocol of Endpoint, one of OTLPProtocolGRPC (default) and
+	// OTLPProtocolHTTP
+	Protocol string
+	Insecure bool
+	Headers  map[string]string
+}
+
+func newOTLPClient(cfg *OTLPConfig) (otlptrace.Client, error) {
+	if cfg.File != "" {
+		return &fileClient{filename: cfg.File}, nil
+	}
+
+	switch cfg.Protocol {
+	case "", OTLPProtocolGRPC:
+		opts := []otlptracegrpc.Option{
+			otlptracegrpc.WithEndpoint(cfg.Endpoint),
+			otlptracegrpc.WithHeaders(cfg.Headers),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		return otlptracegrpc.NewClient(opts...), nil
+	case OTLPProtocolHTTP:
+		opts := []otlptracehttp.Option{
+			otlptracehttp.WithEndpoint(cfg.Endpoint),
+			otlptracehttp.WithHeaders(cfg.Headers),
+		}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.NewClient(opts...), nil
+	}
+	return nil, errors.Errorf("unsupported OTLP protocol %s", cfg.Protocol)
+}
+
+// fileClient writes spans to a file instead of sending them to a collector.
+type fileClient struct {
+	filename string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (c *fileClient) Start(ctx context.Context) error {
+	f, err := os.OpenFile(c.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	c.f = f
+	return nil
+}
+
+func (c *fileClient) Stop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.f.Close()
+}
+
+func (c *fileClient) UploadTraces(ctx context.Context, protoSpans []*tracepb.ResourceSpans) error {
+	dt, err := protojson.Marshal(&coltracepb.ExportTraceServiceRequest{
+		ResourceSpans: protoSpans,
+	})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err = c.f.Write(append(dt, '\n'))
+	return err
+}
+
+// spanWriter turns vertexes of a build into spans below a span for the
+// whole build.
+type spanWriter struct {
+	tp   *sdktrace.TracerProvider
+	ctx  context.Context
+	root trace.Span
+	// Redacted from span names and errors
+	masked masker
+}
+
+func newSpanWriter(ctx context.Context, cfg *OTLPConfig, masked []string) (*spanWriter, error) {
+	c, err := newOTLPClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := otlptrace.New(ctx, c)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating OTLP exporter failed")
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	)
+	spanCtx, root := tp.Tracer(tracerName).Start(ctx, "build")
+	return &spanWriter{
+		tp:     tp,
+		ctx:    spanCtx,
+		root:   root,
+		masked: masked,
+	}, nil
+}
+
+// write creates spans for vertexes completed in the statuses received on ch.
+func (w *spanWriter) write(ch <-chan *client.SolveStatus) {
+	tracer := w.tp.Tracer(tracerName)
+	done := map[string]struct{}{}
+	for s := range ch {
+		for _, v := range s.Vertexes {
+			if v.Started == nil || v.Completed == nil {
+				continue
+			}
+			if _, ok := done[v.Digest.String()]; ok {
+				continue
+			}
+			done[v.Digest.String()] = struct{}{}
+			v = w.masked.vertex(v)
+
+			inputs := make([]string, 0, len(v.Inputs))
+			for _, i := range v.Inputs {
+				inputs = append(inputs, i.String())
+			}
+			_, span := tracer.Start(w.ctx, v.Name,
+				trace.WithTimestamp(*v.Started),
+				trace.WithAttributes(
+					attribute.String("vertex.digest", v.Digest.String()),
+					attribute.StringSlice("vertex.inputs", inputs),
+					attribute.Bool("vertex.cached", v.Cached),
+				),
+			)
+			if v.Error != "" {
+				span.SetAttributes(attribute.String("vertex.error", v.Error))
+				span.SetStatus(codes.Error, v.Error)
+			}
+			span.End(trace.WithTimestamp(*v.Completed))
+		}
+	}
+}
+
+// close ends the span of the build and flushes all spans.
+func (w *spanWriter) close(ctx context.Context, err error) error {
+	if err != nil {
+		msg := w.masked.mask(err.Error())
+		w.root.RecordError(errors.New(msg))
+		w.root.SetStatus(codes.Error, msg)
+	}
+	w.root.End()
+	return w.tp.Shutdown(ctx)
+}
@@ -0,0 +1,190 @@
+package buildctl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/moby/buildkit/client"
+	godigest "github.com/opencontainers/go-digest"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// otlpReceiver collects the spans exported via OTLP over HTTP.
+type otlpReceiver struct {
+	mu    sync.Mutex
+	spans map[string]*tracepb.Span
+}
+
+func (r *otlpReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path != "/v1/traces" {
+		http.NotFound(w, req)
+		return
+	}
+	dt, err := io.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var export coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(dt, &export); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, rs := range export.ResourceSpans {
+		for _, ils := range rs.InstrumentationLibrarySpans {
+			for _, s := range ils.Spans {
+				r.spans[s.Name] = s
+			}
+		}
+	}
+
+	resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(resp)
+}
+
+func TestSpanWriterExportsVertexes(t *testing.T) {
+	rcv := &otlpReceiver{spans: map[string]*tracepb.Span{}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	w, err := newSpanWriter(context.Background(), &OTLPConfig{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Protocol: OTLPProtocolHTTP,
+		Insecure: true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	completed := started.Add(time.Second)
+	ch := make(chan *client.SolveStatus, 2)
+	ch <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{
+			{
+				Digest:    godigest.FromString("a"),
+				Name:      "[1/2] FROM alpine",
+				Started:   &started,
+				Completed: &completed,
+				Cached:    true,
+			},
+			{
+				Digest:  godigest.FromString("b"),
+				Name:    "[2/2] RUN make",
+				Started: &started,
+			},
+		},
+	}
+	ch <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{
+			{
+				Digest:    godigest.FromString("b"),
+				Name:      "[2/2] RUN make",
+				Started:   &started,
+				Completed: &completed,
+				Error:     "exit code 2",
+			},
+		},
+	}
+	close(ch)
+	w.write(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), spanCloseTimeout)
+	defer cancel()
+	if err := w.close(ctx, errors.New("build failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	if len(rcv.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(rcv.spans))
+	}
+
+	root, ok := rcv.spans["build"]
+	if !ok {
+		t.Fatal("span of the build not exported")
+	}
+	if root.Status.Code != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected span of the build to fail, got %s", root.Status.Code)
+	}
+
+	for _, name := range []string{"[1/2] FROM alpine", "[2/2] RUN make"} {
+		s, ok := rcv.spans[name]
+		if !ok {
+			t.Fatalf("span of vertex %s not exported", name)
+		}
+		if string(s.ParentSpanId) != string(root.SpanId) {
+			t.Errorf("span of vertex %s is not a child of the build", name)
+		}
+		if s.StartTimeUnixNano != uint64(started.UnixNano()) || s.EndTimeUnixNano != uint64(completed.UnixNano()) {
+			t.Errorf("span of vertex %s does not match the vertex timestamps", name)
+		}
+	}
+	if got := rcv.spans["[2/2] RUN make"].Status.Code; got != tracepb.Status_STATUS_CODE_ERROR {
+		t.Errorf("expected span of failed vertex to fail, got %s", got)
+	}
+}
+
+func TestSpanWriterMasksSensitiveValues(t *testing.T) {
+	rcv := &otlpReceiver{spans: map[string]*tracepb.Span{}}
+	srv := httptest.NewServer(rcv)
+	defer srv.Close()
+
+	w, err := newSpanWriter(context.Background(), &OTLPConfig{
+		Endpoint: strings.TrimPrefix(srv.URL, "http://"),
+		Protocol: OTLPProtocolHTTP,
+		Insecure: true,
+	}, []string{"s3cr3t"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	ch := make(chan *client.SolveStatus, 1)
+	ch <- &client.SolveStatus{
+		Vertexes: []*client.Vertex{
+			{
+				Digest:    godigest.FromString("a"),
+				Name:      "[1/1] RUN login --token=s3cr3t",
+				Started:   &started,
+				Completed: &started,
+				Error:     "login --token=s3cr3t failed",
+			},
+		},
+	}
+	close(ch)
+	w.write(ch)
+
+	ctx, cancel := context.WithTimeout(context.Background(), spanCloseTimeout)
+	defer cancel()
+	if err := w.close(ctx, errors.New("running login --token=s3cr3t failed")); err != nil {
+		t.Fatal(err)
+	}
+
+	rcv.mu.Lock()
+	defer rcv.mu.Unlock()
+	s, ok := rcv.spans["[1/1] RUN login --token=***"]
+	if !ok {
+		t.Fatalf("span of vertex with masked name not exported")
+	}
+	if strings.Contains(s.Status.Message, "s3cr3t") {
+		t.Errorf("sensitive value in span status %q", s.Status.Message)
+	}
+	if root := rcv.spans["build"]; strings.Contains(root.Status.Message, "s3cr3t") {
+		t.Errorf("sensitive value in status of the build %q", root.Status.Message)
+	}
+}
@@ -0,0 +1,198 @@
+package buildctl
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/util/progress/progresswriter"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// LogSubsystem is the tflog subsystem build progress is logged to
+	LogSubsystem = "buildkit"
+	// Replacement of sensitive values, same as tflog uses
+	maskedValue = "***"
+)
+
+// masker redacts sensitive values from progress leaving the provider
+// other than via tflog, e.g. vertex names with expanded build args.
+type masker []string
+
+func (m masker) mask(s string) string {
+	for _, v := range m {
+		if v != "" {
+			s = strings.ReplaceAll(s, v, maskedValue)
+		}
+	}
+	return s
+}
+
+// vertex returns a copy of v with name and error masked. Vertexes are
+// shared by all writers of a Tee, so they must not be modified in place.
+func (m masker) vertex(v *client.Vertex) *client.Vertex {
+	if len(m) == 0 {
+		return v
+	}
+	c := *v
+	c.Name = m.mask(v.Name)
+	c.Error = m.mask(v.Error)
+	return &c
+}
+
+type logWriter struct {
+	ctx    context.Context
+	status chan *client.SolveStatus
+	done   chan struct{}
+
+	vertexes map[digest.Digest]*loggedVertex
+}
+
+type loggedVertex struct {
+	name      string
+	started   bool
+	completed bool
+	// Incomplete last line per stream
+	partial map[int][]byte
+}
+
+// NewLogWriter creates a progresswriter.Writer logging vertex starts,
+// completions, cache hits, warnings and log lines to the LogSubsystem of
+// tflog. Occurrences of masked are redacted.
+func NewLogWriter(ctx context.Context, masked []string) progresswriter.Writer {
+	ctx = tflog.NewSubsystem(ctx, LogSubsystem)
+	if len(masked) != 0 {
+		ctx = tflog.SubsystemMaskAllFieldValuesStrings(ctx, LogSubsystem, masked...)
+		ctx = tflog.SubsystemMaskMessageStrings(ctx, LogSubsystem, masked...)
+	}
+
+	w := &logWriter{
+		ctx:      ctx,
+		status:   make(chan *client.SolveStatus),
+		done:     make(chan struct{}),
+		vertexes: map[digest.Digest]*loggedVertex{},
+	}
+	go func() {
+		defer close(w.done)
+		for s := range w.status {
+			w.log(s)
+		}
+		w.flush()
+	}()
+	return w
+}
+
+func (w *logWriter) Status() chan *client.SolveStatus {
+	return w.status
+}
+
+func (w *logWriter) Done() <-chan struct{} {
+	return w.done
+}
+
+func (w *logWriter) Err() error {
+	return nil
+}
+
+func (w *logWriter) vertex(dgst digest.Digest) *loggedVertex {
+	v, ok := w.vertexes[dgst]
+	if !ok {
+		v = &loggedVertex{
+			partial: map[int][]byte{},
+		}
+		w.vertexes[dgst] = v
+	}
+	return v
+}
+
+func (w *logWriter) fields(dgst digest.Digest, v *loggedVertex) map[string]interface{} {
+	return map[string]interface{}{
+		"vertex_digest": dgst.String(),
+		"vertex_name":   v.name,
+	}
+}
+
+func (w *logWriter) log(s *client.SolveStatus) {
+	for _, vtx := range s.Vertexes {
+		v := w.vertex(vtx.Digest)
+		v.name = vtx.Name
+		fields := w.fields(vtx.Digest, v)
+
+		if vtx.Started != nil && !v.started {
+			v.started = true
+			tflog.SubsystemDebug(w.ctx, LogSubsystem, "Vertex started", fields)
+		}
+		if vtx.Completed == nil || v.completed {
+			continue
+		}
+		v.completed = true
+		if vtx.Started != nil {
+			fields["duration"] = vtx.Completed.Sub(*vtx.Started).Round(time.Millisecond).String()
+		}
+		switch {
+		case vtx.Error != "":
+			fields["error"] = vtx.Error
+			tflog.SubsystemError(w.ctx, LogSubsystem, "Vertex failed", fields)
+		case vtx.Cached:
+			tflog.SubsystemInfo(w.ctx, LogSubsystem, "Vertex cached", fields)
+		default:
+			tflog.SubsystemInfo(w.ctx, LogSubsystem, "Vertex completed", fields)
+		}
+	}
+
+	for _, st := range s.Statuses {
+		if st.Completed == nil {
+			continue
+		}
+		fields := w.fields(st.Vertex, w.vertex(st.Vertex))
+		fields["status"] = st.ID
+		if st.Total != 0 {
+			fields["total"] = st.Total
+		}
+		tflog.SubsystemTrace(w.ctx, LogSubsystem, "Vertex status completed", fields)
+	}
+
+	for _, l := range s.Logs {
+		v := w.vertex(l.Vertex)
+		data := append(v.partial[l.Stream], l.Data...)
+		for {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			w.logLine(l.Vertex, v, l.Stream, data[:i])
+			data = data[i+1:]
+		}
+		v.partial[l.Stream] = data
+	}
+
+	for _, warn := range s.Warnings {
+		fields := w.fields(warn.Vertex, w.vertex(warn.Vertex))
+		if warn.URL != "" {
+			fields["url"] = warn.URL
+		}
+		tflog.SubsystemWarn(w.ctx, LogSubsystem, string(warn.Short), fields)
+	}
+}
+
+func (w *logWriter) logLine(dgst digest.Digest, v *loggedVertex, stream int, line []byte) {
+	fields := w.fields(dgst, v)
+	fields["stream"] = stream
+	fields["line"] = string(bytes.TrimSuffix(line, []byte("\r")))
+	tflog.SubsystemDebug(w.ctx, LogSubsystem, "Vertex log", fields)
+}
+
+// flush logs lines not terminated by a newline.
+func (w *logWriter) flush() {
+	for dgst, v := range w.vertexes {
+		for stream, data := range v.partial {
+			if len(data) != 0 {
+				w.logLine(dgst, v, stream, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package buildctl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/distribution/reference"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	InTotoPayloadType       = "application/vnd.in-toto+json"
+	DSSEEnvelopeMediaType   = "application/vnd.dsse.envelope.v1+json"
+	InTotoStatementType     = "https://in-toto.io/Statement/v0.1"
+	SLSAProvenanceType      = "https://slsa.dev/provenance/v0.2"
+	provenanceBuildType     = "https://mobyproject.org/buildkit@v1"
+	provenanceBuilderID     = "https://github.com/abergmeier/terraform-provider-buildkit"
+	dockerImageSchemePrefix = "docker-image://"
+)
+
+// fromVertex matches vertexes of the dockerfile frontend pulling a base
+// image, which is pinned to a digest in the name.
+var fromVertex = regexp.MustCompile(`\bFROM (\S+@sha256:[0-9a-f]{64})`)
+
+// Statement is an in-toto statement with SLSA provenance as predicate.
+type Statement struct {
+	Type          string     `json:"_type"`
+	PredicateType string     `json:"predicateType"`
+	Subject       []Subject  `json:"subject"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Envelope is a DSSE envelope holding a statement, as cosign attaches
+// attestations to images.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// NewEnvelope wraps the marshalled statement dt into an unsigned envelope.
+func NewEnvelope(dt []byte) *Envelope {
+	return &Envelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     dt,
+		Signatures:  []Signature{},
+	}
+}
+
+// AttestationTag is the tag cosign stores attestations of the manifest dgst
+// under.
+func AttestationTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s.att", dgst.Algorithm(), dgst.Encoded())
+}
+
+// Subject is an artifact produced by the build.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is the SLSA v0.2 provenance predicate.
+type Provenance struct {
+	Builder     ProvenanceBuilder     `json:"builder"`
+	BuildType   string                `json:"buildType"`
+	Invocation  ProvenanceInvocation  `json:"invocation"`
+	BuildConfig ProvenanceBuildConfig `json:"buildConfig"`
+	Metadata    ProvenanceMetadata    `json:"metadata"`
+	Materials   []Material            `json:"materials"`
+}
+
+type ProvenanceBuilder struct {
+	ID string `json:"id"`
+}
+
+type ProvenanceInvocation struct {
+	ConfigSource ProvenanceConfigSource `json:"configSource"`
+	Parameters   ProvenanceParameters   `json:"parameters"`
+}
+
+type ProvenanceConfigSource struct {
+	URI        string            `json:"uri,omitempty"`
+	Digest     map[string]string `json:"digest,omitempty"`
+	EntryPoint string            `json:"entryPoint,omitempty"`
+}
+
+type ProvenanceParameters struct {
+	Frontend string            `json:"frontend,omitempty"`
+	Args     map[string]string `json:"args,omitempty"`
+	Locals   []string          `json:"locals,omitempty"`
+}
+
+type ProvenanceBuildConfig struct {
+	Steps []ProvenanceStep `json:"steps"`
+}
+
+// ProvenanceStep is a vertex of the build.
+type ProvenanceStep struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Inputs    []string   `json:"inputs,omitempty"`
+	Cached    bool       `json:"cached,omitempty"`
+	Started   *time.Time `json:"started,omitempty"`
+	Completed *time.Time `json:"completed,omitempty"`
+}
+
+type ProvenanceMetadata struct {
+	BuildStartedOn  *time.Time             `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time             `json:"buildFinishedOn,omitempty"`
+	Completeness    ProvenanceCompleteness `json:"completeness"`
+	Reproducible    bool                   `json:"reproducible"`
+}
+
+type ProvenanceCompleteness struct {
+	Parameters  bool `json:"parameters"`
+	Environment bool `json:"environment"`
+	Materials   bool `json:"materials"`
+}
+
+// Material is an input of the build.
+type Material struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+// NewMaterial creates a material with the digest dgst.
+func NewMaterial(uri string, dgst digest.Digest) Material {
+	return Material{
+		URI: uri,
+		Digest: map[string]string{
+			dgst.Algorithm().String(): dgst.Encoded(),
+		},
+	}
+}
+
+// ImageMaterial creates a material of a digested image reference, e.g.
+// `alpine@sha256:...`. ok is false for references without digest.
+func ImageMaterial(ref string) (Material, bool) {
+	named, err := reference.ParseNormalizedNamed(strings.TrimPrefix(ref, dockerImageSchemePrefix))
+	if err != nil {
+		return Material{}, false
+	}
+	digested, ok := named.(reference.Digested)
+	if !ok {
+		return Material{}, false
+	}
+	return NewMaterial("pkg:docker/"+reference.TrimNamed(named).String(), digested.Digest()), true
+}
+
+// ProvenanceRecorder records what happened during a build for its
+// provenance.
+type ProvenanceRecorder struct {
+	mu        sync.Mutex
+	started   time.Time
+	finished  time.Time
+	steps     []ProvenanceStep
+	materials []Material
+}
+
+func (p *ProvenanceRecorder) record(started, finished time.Time, vertexes []*client.Vertex, def *llb.Definition) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.started = started
+	p.finished = finished
+	p.steps = make([]ProvenanceStep, 0, len(vertexes))
+	seen := map[string]struct{}{}
+	for _, v := range vertexes {
+		inputs := make([]string, 0, len(v.Inputs))
+		for _, i := range v.Inputs {
+			inputs = append(inputs, i.String())
+		}
+		p.steps = append(p.steps, ProvenanceStep{
+			ID:        v.Digest.String(),
+			Name:      v.Name,
+			Inputs:    inputs,
+			Cached:    v.Cached,
+			Started:   v.Started,
+			Completed: v.Completed,
+		})
+		if m := fromVertex.FindStringSubmatch(v.Name); m != nil {
+			p.addMaterial(m[1], seen)
+		}
+	}
+	if def != nil {
+		for _, dt := range def.Def {
+			var op pb.Op
+			if err := op.Unmarshal(dt); err != nil {
+				continue
+			}
+			if src := op.GetSource(); src != nil {
+				p.addMaterial(src.Identifier, seen)
+			}
+		}
+	}
+}
+
+func (p *ProvenanceRecorder) addMaterial(ref string, seen map[string]struct{}) {
+	m, ok := ImageMaterial(ref)
+	if !ok {
+		return
+	}
+	key := fmt.Sprint(m.URI, m.Digest)
+	if _, ok := seen[key]; ok {
+		return
+	}
+	seen[key] = struct{}{}
+	p.materials = append(p.materials, m)
+}
+
+// Statement assembles the provenance of the recorded build. inv describes
+// how the build was invoked, materials are inputs known by the caller.
+func (p *ProvenanceRecorder) Statement(subjects []Subject, inv ProvenanceInvocation, materials []Material) *Statement {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	all := append(append([]Material{}, p.materials...), materials...)
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].URI < all[j].URI
+	})
+
+	s := &Statement{
+		Type:          InTotoStatementType,
+		PredicateType: SLSAProvenanceType,
+		Subject:       subjects,
+		Predicate: Provenance{
+			Builder: ProvenanceBuilder{
+				ID: provenanceBuilderID,
+			},
+			BuildType:  provenanceBuildType,
+			Invocation: inv,
+			BuildConfig: ProvenanceBuildConfig{
+				Steps: p.steps,
+			},
+			Metadata: ProvenanceMetadata{
+				Completeness: ProvenanceCompleteness{
+					Parameters: true,
+				},
+			},
+			Materials: all,
+		},
+	}
+	if !p.started.IsZero() {
+		s.Predicate.Metadata.BuildStartedOn = &p.started
+		s.Predicate.Metadata.BuildFinishedOn = &p.finished
+	}
+	return s
+}
@@ -0,0 +1,133 @@
+package buildctl
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform-plugin-log/tflog"
+	"github.com/moby/buildkit/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Classes of transient errors a build can be retried on
+const (
+	RetryClassRegistry = "registry"
+	RetryClassNetwork  = "network"
+	RetryClassGRPC     = "grpc"
+)
+
+// RetryClasses are all classes of transient errors.
+var RetryClasses = []string{RetryClassRegistry, RetryClassNetwork, RetryClassGRPC}
+
+var (
+	// Failures which will fail the same way again, even if caused by
+	// network errors within the build
+	deterministicErrors = regexp.MustCompile(`did not complete successfully|executor failed running|failed to compute cache key|dockerfile parse error|failed to parse`)
+
+	retryableErrors = map[string]*regexp.Regexp{
+		RetryClassRegistry: regexp.MustCompile(`unexpected status( code)?:? 5\d\d|\b5\d\d (Internal Server Error|Bad Gateway|Service Unavailable|Gateway Timeout)|429 Too Many Requests|toomanyrequests`),
+		RetryClassNetwork:  regexp.MustCompile(`connection reset by peer|broken pipe|i/o timeout|TLS handshake timeout|unexpected EOF|connection refused|temporary failure in name resolution`),
+		RetryClassGRPC:     regexp.MustCompile(`code = Unavailable|transport is closing|RST_STREAM|error reading from server|stream terminated by`),
+	}
+)
+
+// RetryConfig configures retrying builds, which failed with transient
+// errors.
+type RetryConfig struct {
+	// Attempts in total, including the first one
+	Attempts int
+	// Backoff before the first retry, doubled for every further one
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Classes of errors to retry on. Empty for all RetryClasses
+	Classes []string
+}
+
+// RetryError collects the errors of all attempts of a build.
+type RetryError struct {
+	Errors []error
+}
+
+func (e *RetryError) Error() string {
+	msgs := make([]string, 0, len(e.Errors))
+	for i, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("attempt %d: %v", i+1, err))
+	}
+	return fmt.Sprintf("build failed after %d attempts:\n%s", len(e.Errors), strings.Join(msgs, "\n"))
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Errors[len(e.Errors)-1]
+}
+
+// retryClass returns the class of err in case it is transient and one of
+// classes.
+func retryClass(err error, classes []string) (string, bool) {
+	if len(classes) == 0 {
+		classes = RetryClasses
+	}
+	msg := err.Error()
+	if deterministicErrors.MatchString(msg) {
+		return "", false
+	}
+	for _, class := range classes {
+		if class == RetryClassGRPC && status.Code(err) == codes.Unavailable {
+			return class, true
+		}
+		if re, ok := retryableErrors[class]; ok && re.MatchString(msg) {
+			return class, true
+		}
+	}
+	return "", false
+}
+
+// solveWithRetry calls solve until it succeeds, fails with a non transient
+// error or cfg.Attempts are exhausted.
+func solveWithRetry(ctx context.Context, cfg *RetryConfig, solve func() (*client.SolveResponse, error)) (*client.SolveResponse, error) {
+	if cfg == nil || cfg.Attempts <= 1 {
+		return solve()
+	}
+
+	backoff := cfg.Backoff
+	var errs []error
+	for attempt := 1; ; attempt++ {
+		resp, err := solve()
+		if err == nil {
+			return resp, nil
+		}
+		errs = append(errs, err)
+
+		fields := map[string]interface{}{
+			"attempt":  attempt,
+			"attempts": cfg.Attempts,
+			"error":    err.Error(),
+		}
+		class, ok := retryClass(err, cfg.Classes)
+		if !ok || attempt >= cfg.Attempts || ctx.Err() != nil {
+			tflog.Warn(ctx, "Build attempt failed", fields)
+			if len(errs) == 1 {
+				return nil, err
+			}
+			return nil, &RetryError{Errors: errs}
+		}
+
+		fields["class"] = class
+		fields["backoff"] = backoff.String()
+		tflog.Warn(ctx, "Build attempt failed, retrying", fields)
+
+		select {
+		case <-ctx.Done():
+			errs = append(errs, ctx.Err())
+			return nil, &RetryError{Errors: errs}
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if cfg.MaxBackoff > 0 && backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
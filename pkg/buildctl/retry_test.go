@@ -0,0 +1,169 @@
+package buildctl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/moby/buildkit/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRetryClass(t *testing.T) {
+	tests := map[string]struct {
+		err     error
+		classes []string
+		class   string
+	}{
+		"grpc unavailable": {
+			err:   status.Error(codes.Unavailable, "connection closed"),
+			class: RetryClassGRPC,
+		},
+		"grpc transport closing": {
+			err:   errors.New("rpc error: code = Canceled desc = grpc: the client connection is closing: transport is closing"),
+			class: RetryClassGRPC,
+		},
+		"registry server error": {
+			err:   errors.New("failed to copy: httpReadSeeker: failed open: unexpected status code https://registry-1.docker.io/v2/library/alpine/blobs/sha256:abc: 503 Service Unavailable"),
+			class: RetryClassRegistry,
+		},
+		"registry rate limited": {
+			err:   errors.New("failed to resolve source metadata for docker.io/library/alpine:latest: 429 Too Many Requests - Server message: toomanyrequests: You have reached your pull rate limit"),
+			class: RetryClassRegistry,
+		},
+		"network reset": {
+			err:   errors.New("failed to do request: Head \"https://registry-1.docker.io/v2/\": read tcp 10.0.0.2:4711->1.2.3.4:443: read: connection reset by peer"),
+			class: RetryClassNetwork,
+		},
+		"network timeout": {
+			err:   errors.New("dial tcp 1.2.3.4:443: i/o timeout"),
+			class: RetryClassNetwork,
+		},
+		"class not enabled": {
+			err:     errors.New("dial tcp 1.2.3.4:443: i/o timeout"),
+			classes: []string{RetryClassRegistry},
+		},
+		"grpc not enabled": {
+			err:     status.Error(codes.Unavailable, "connection closed"),
+			classes: []string{RetryClassNetwork},
+		},
+		"failing step": {
+			err: errors.New("process \"/bin/sh -c make\" did not complete successfully: exit code: 2"),
+		},
+		"failing step with network error": {
+			err: errors.New("process \"/bin/sh -c curl -fsSL https://example.com\" did not complete successfully: exit code: 56: connection reset by peer"),
+		},
+		"failing step of older daemons": {
+			err: errors.New("executor failed running [/bin/sh -c make]: exit code: 2"),
+		},
+		"dockerfile parse error": {
+			err: errors.New("failed to solve: dockerfile parse error line 3: unknown instruction: RUNN"),
+		},
+		"auth denied": {
+			err: errors.New("failed to authorize: failed to fetch anonymous token: unexpected status: 401 Unauthorized"),
+		},
+		"pull access denied": {
+			err: errors.New("pull access denied, repository does not exist or may require authorization: server message: insufficient_scope: authorization failed"),
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			class, ok := retryClass(tt.err, tt.classes)
+			if ok != (tt.class != "") || class != tt.class {
+				t.Errorf("got class %q (retry %t), want %q", class, ok, tt.class)
+			}
+		})
+	}
+}
+
+func TestSolveWithRetry(t *testing.T) {
+	tests := map[string]struct {
+		errs     []error
+		attempts int
+		fail     bool
+	}{
+		"transient": {
+			errs:     []error{errors.New("dial tcp 1.2.3.4:443: i/o timeout"), nil},
+			attempts: 2,
+		},
+		"deterministic": {
+			errs:     []error{errors.New("process \"/bin/sh -c make\" did not complete successfully: exit code: 2"), nil},
+			attempts: 1,
+			fail:     true,
+		},
+		"exhausted": {
+			errs: []error{
+				errors.New("dial tcp 1.2.3.4:443: i/o timeout"),
+				errors.New("dial tcp 1.2.3.4:443: i/o timeout"),
+				errors.New("dial tcp 1.2.3.4:443: i/o timeout"),
+				nil,
+			},
+			attempts: 3,
+			fail:     true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			attempts := 0
+			_, err := solveWithRetry(context.Background(), &RetryConfig{Attempts: 3}, func() (*client.SolveResponse, error) {
+				err := tt.errs[attempts]
+				attempts++
+				if err != nil {
+					return nil, err
+				}
+				return &client.SolveResponse{}, nil
+			})
+			if attempts != tt.attempts {
+				t.Errorf("got %d attempts, want %d", attempts, tt.attempts)
+			}
+			if (err != nil) != tt.fail {
+				t.Errorf("got error %v", err)
+			}
+			var retryErr *RetryError
+			if tt.attempts > 1 && tt.fail && !errors.As(err, &retryErr) {
+				t.Errorf("expected errors of all attempts, got %v", err)
+			}
+		})
+	}
+}
+
+func TestStreamsOutput(t *testing.T) {
+	output := func(map[string]string) (io.WriteCloser, error) {
+		return nil, nil
+	}
+	tests := map[string]struct {
+		exports []client.ExportEntry
+		streams bool
+	}{
+		"none": {},
+		"image": {
+			exports: []client.ExportEntry{
+				{Type: client.ExporterImage, Attrs: map[string]string{"push": "true"}},
+			},
+		},
+		"local directory": {
+			exports: []client.ExportEntry{
+				{Type: client.ExporterLocal, OutputDir: "out"},
+			},
+		},
+		"tarball": {
+			exports: []client.ExportEntry{
+				{Type: client.ExporterImage},
+				{Type: client.ExporterTar, Output: output},
+			},
+			streams: true,
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if got := streamsOutput(tt.exports); got != tt.streams {
+				t.Errorf("got %t, want %t", got, tt.streams)
+			}
+		})
+	}
+}
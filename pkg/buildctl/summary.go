@@ -0,0 +1,188 @@
+package buildctl
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/moby/buildkit/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// ProgressSummary describes how far a build got.
+type ProgressSummary struct {
+	Total     int
+	Completed int
+	Cached    int
+	// Names of vertexes started, but not completed
+	Running []string
+}
+
+func (s ProgressSummary) String() string {
+	msg := fmt.Sprintf("%d of %d steps completed (%d cached)", s.Completed, s.Total, s.Cached)
+	if len(s.Running) != 0 {
+		msg += fmt.Sprintf(", running: %s", strings.Join(s.Running, ", "))
+	}
+	return msg
+}
+
+// InterruptedError is returned by BuildAction in case the build was
+// cancelled or timed out.
+type InterruptedError struct {
+	Summary ProgressSummary
+	Err     error
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("build interrupted: %s: %v", e.Summary, e.Err)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// progressTracker records the state and last log lines of all vertexes of a
+// build.
+type progressTracker struct {
+	mu       sync.Mutex
+	vertexes map[digest.Digest]*client.Vertex
+	logs     map[digest.Digest]*logTail
+	// Last vertex which failed
+	failed digest.Digest
+	masked masker
+}
+
+// newProgressTracker creates a tracker, which redacts occurrences of masked
+// from everything it returns.
+func newProgressTracker(masked []string) *progressTracker {
+	return &progressTracker{
+		vertexes: map[digest.Digest]*client.Vertex{},
+		logs:     map[digest.Digest]*logTail{},
+		masked:   masked,
+	}
+}
+
+func (t *progressTracker) write(ch <-chan *client.SolveStatus) {
+	for s := range ch {
+		t.mu.Lock()
+		for _, v := range s.Vertexes {
+			t.vertexes[v.Digest] = t.masked.vertex(v)
+			if v.Error != "" {
+				t.failed = v.Digest
+			}
+		}
+		for _, l := range s.Logs {
+			lt, ok := t.logs[l.Vertex]
+			if !ok {
+				lt = &logTail{}
+				t.logs[l.Vertex] = lt
+			}
+			lt.write(l.Data)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// failure returns the vertex which failed last with its last log lines.
+// Nil if no vertex failed.
+func (t *progressTracker) failure(err error) *FailureError {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	v, ok := t.vertexes[t.failed]
+	if !ok {
+		return nil
+	}
+	e := &FailureError{
+		Vertex: v.Name,
+		Digest: v.Digest,
+		Err:    err,
+		masked: t.masked,
+	}
+	if lt, ok := t.logs[v.Digest]; ok {
+		// Lines are masked once complete, values may span writes
+		for _, l := range lt.tail() {
+			e.Logs = append(e.Logs, t.masked.mask(l))
+		}
+	}
+	return e
+}
+
+// vertexList returns all vertexes in the order they were started.
+func (t *progressTracker) vertexList() []*client.Vertex {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	vertexes := make([]*client.Vertex, 0, len(t.vertexes))
+	for _, v := range t.vertexes {
+		vertexes = append(vertexes, v)
+	}
+	sort.SliceStable(vertexes, func(i, j int) bool {
+		a, b := vertexes[i].Started, vertexes[j].Started
+		switch {
+		case a == nil || b == nil:
+			return a != nil && b == nil
+		case a.Equal(*b):
+			return vertexes[i].Digest < vertexes[j].Digest
+		}
+		return a.Before(*b)
+	})
+	return vertexes
+}
+
+func (t *progressTracker) summary() ProgressSummary {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := ProgressSummary{
+		Total: len(t.vertexes),
+	}
+	for _, v := range t.vertexes {
+		switch {
+		case v.Completed != nil:
+			s.Completed++
+			if v.Cached {
+				s.Cached++
+			}
+		case v.Started != nil:
+			s.Running = append(s.Running, v.Name)
+		}
+	}
+	sort.Strings(s.Running)
+	return s
+}
+
+// logTail keeps the last failureLogLines lines written.
+type logTail struct {
+	lines   []string
+	partial []byte
+}
+
+func (lt *logTail) write(dt []byte) {
+	dt = append(lt.partial, dt...)
+	for {
+		i := bytes.IndexByte(dt, '\n')
+		if i < 0 {
+			break
+		}
+		lt.lines = append(lt.lines, strings.TrimSuffix(string(dt[:i]), "\r"))
+		dt = dt[i+1:]
+	}
+	lt.partial = append([]byte(nil), dt...)
+	if len(lt.lines) > failureLogLines {
+		lt.lines = append([]string(nil), lt.lines[len(lt.lines)-failureLogLines:]...)
+	}
+}
+
+func (lt *logTail) tail() []string {
+	lines := append([]string(nil), lt.lines...)
+	if len(lt.partial) != 0 {
+		lines = append(lines, string(lt.partial))
+	}
+	if len(lines) > failureLogLines {
+		lines = lines[len(lines)-failureLogLines:]
+	}
+	return lines
+}
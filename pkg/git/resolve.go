@@ -0,0 +1,70 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	commitSHA = regexp.MustCompile(`^[0-9a-f]{40}$`)
+	// Abbreviated SHAs are not advertised by remotes
+	shortCommitSHA = regexp.MustCompile(`^[0-9a-f]{4,39}$`)
+)
+
+// ResolveRef resolves ref of the remote repository at url to a commit SHA.
+// An empty ref resolves HEAD. Abbreviated commit SHAs cannot be resolved
+// without cloning and are rejected. Requires git to be installed.
+func ResolveRef(ctx context.Context, url string, ref string) (string, error) {
+	if commitSHA.MatchString(ref) {
+		return ref, nil
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+
+	var stdout, stderr bytes.Buffer
+	// Peeled tags are only listed when matched explicitly
+	cmd := exec.CommandContext(ctx, "git", "ls-remote", "--", url, ref, ref+"^{}")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrapf(err, "git ls-remote %s %s failed: %s", url, ref, strings.TrimSpace(stderr.String()))
+	}
+
+	refs := map[string]string{}
+	s := bufio.NewScanner(&stdout)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		refs[fields[1]] = fields[0]
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+
+	// Peeled annotated tags point to the commit instead of the tag object
+	for _, name := range []string{
+		ref,
+		"refs/heads/" + ref,
+		"refs/tags/" + ref + "^{}",
+		"refs/tags/" + ref,
+	} {
+		if sha, ok := refs[name]; ok {
+			return sha, nil
+		}
+	}
+	if shortCommitSHA.MatchString(ref) {
+		return "", errors.Errorf("ref %s not found in %s, abbreviated commit SHAs are not supported, use the full 40 character SHA", ref, url)
+	}
+	return "", errors.Errorf("ref %s not found in %s", ref, url)
+}
@@ -0,0 +1,127 @@
+package git
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func runGit(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test",
+		"GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test",
+		"GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1",
+		"HOME="+dir,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newRemote creates a bare repository with a main branch of two commits, a
+// feature branch at the first commit and an annotated tag at the first
+// commit. Returns the path of the bare repository and both commits.
+func newRemote(t *testing.T) (string, string, string) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	dir := t.TempDir()
+	work := filepath.Join(dir, "work")
+	bare := filepath.Join(dir, "remote.git")
+	if err := os.Mkdir(work, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	runGit(t, work, "init", "-q", "-b", "main")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "first")
+	first := runGit(t, work, "rev-parse", "HEAD")
+	runGit(t, work, "tag", "-a", "v1", "-m", "v1")
+	runGit(t, work, "branch", "feature")
+	runGit(t, work, "commit", "-q", "--allow-empty", "-m", "second")
+	second := runGit(t, work, "rev-parse", "HEAD")
+
+	runGit(t, dir, "clone", "-q", "--bare", work, bare)
+	// Required for serving the repository via dumb HTTP
+	runGit(t, bare, "update-server-info")
+	return bare, first, second
+}
+
+func testResolveRef(t *testing.T, url string, first string, second string) {
+	tests := map[string]struct {
+		ref  string
+		want string
+		err  string
+	}{
+		"head": {
+			want: second,
+		},
+		"branch": {
+			ref:  "feature",
+			want: first,
+		},
+		"qualified branch": {
+			ref:  "refs/heads/main",
+			want: second,
+		},
+		"annotated tag": {
+			ref:  "v1",
+			want: first,
+		},
+		"full sha": {
+			ref:  first,
+			want: first,
+		},
+		"short sha": {
+			ref: first[:7],
+			err: "use the full 40 character SHA",
+		},
+		"missing ref": {
+			ref: "missing",
+			err: "ref missing not found",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			got, err := ResolveRef(context.Background(), url, tt.ref)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveRefFile(t *testing.T) {
+	bare, first, second := newRemote(t)
+	testResolveRef(t, "file://"+bare, first, second)
+}
+
+func TestResolveRefHTTP(t *testing.T) {
+	bare, first, second := newRemote(t)
+	srv := httptest.NewServer(http.FileServer(http.Dir(bare)))
+	defer srv.Close()
+	testResolveRef(t, srv.URL, first, second)
+}
@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// HostConfig configures how a registry host is reached, similar to the
+// registry section of buildkitd.toml.
+type HostConfig struct {
+	// Hosts pulls are tried on first, in order
+	Mirrors []string
+	// Talk plain HTTP instead of HTTPS
+	PlainHTTP bool
+	// Skip verifying the certificate of the host
+	Insecure bool
+	// Files of PEM encoded CA certificates trusted in addition to the
+	// system ones
+	RootCAs []string
+}
+
+// Hosts configures registry hosts by host name, e.g. `docker.io` or
+// `localhost:5000`.
+type Hosts map[string]HostConfig
+
+// endpoint is a host requests are sent to.
+type endpoint struct {
+	// Host name credentials are looked up for
+	domain string
+	// Host name requests are sent to
+	host   string
+	scheme string
+	client *http.Client
+}
+
+// NewClientWithHosts creates a client, which reaches registries as
+// configured by hosts.
+func NewClientWithHosts(hosts Hosts) (*Client, error) {
+	c := NewClient()
+	c.hosts = hosts
+	c.clients = make(map[string]*http.Client, len(hosts))
+	for host, cfg := range hosts {
+		if !cfg.Insecure && len(cfg.RootCAs) == 0 {
+			continue
+		}
+		tlsConfig := &tls.Config{
+			InsecureSkipVerify: cfg.Insecure,
+		}
+		if len(cfg.RootCAs) != 0 {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			for _, f := range cfg.RootCAs {
+				dt, err := os.ReadFile(f)
+				if err != nil {
+					return nil, errors.Wrapf(err, "reading CA certificates of %s failed", host)
+				}
+				if !pool.AppendCertsFromPEM(dt) {
+					return nil, errors.Errorf("no CA certificates found in %s", f)
+				}
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		c.clients[host] = &http.Client{
+			Transport: transport,
+		}
+	}
+	return c, nil
+}
+
+// Insecure reports whether host is talked to via plain HTTP or without
+// verifying its certificate.
+func (c *Client) Insecure(host string) bool {
+	cfg, ok := c.hosts[host]
+	return ok && (cfg.PlainHTTP || cfg.Insecure)
+}
+
+// HTTPClient returns the client reaching host with its TLS configuration.
+func (c *Client) HTTPClient(host string) *http.Client {
+	if client, ok := c.clients[host]; ok {
+		return client
+	}
+	return c.client
+}
+
+// endpoints lists the hosts to try for requests to the registry host.
+// Mirrors are only tried for mirrored requests. Mirrors are reached the way
+// host is configured, unless configured themselves.
+func (c *Client) endpoints(host string, mirrored bool) []endpoint {
+	endpoints := []endpoint{}
+	if mirrored {
+		for _, m := range c.hosts[host].Mirrors {
+			cfg := host
+			if _, ok := c.hosts[m]; ok {
+				cfg = m
+			}
+			endpoints = append(endpoints, c.configuredEndpoint(m, cfg))
+		}
+	}
+	return append(endpoints, c.endpoint(host))
+}
+
+func (c *Client) endpoint(host string) endpoint {
+	return c.configuredEndpoint(host, host)
+}
+
+// configuredEndpoint sends requests to host as the host cfg is configured.
+func (c *Client) configuredEndpoint(host string, cfg string) endpoint {
+	ep := endpoint{
+		domain: host,
+		host:   host,
+		scheme: "https",
+		client: c.client,
+	}
+	if host == "docker.io" {
+		ep.host = "registry-1.docker.io"
+	}
+	if c.hosts[cfg].PlainHTTP {
+		ep.scheme = "http"
+	}
+	if client, ok := c.clients[cfg]; ok {
+		ep.client = client
+	}
+	return ep
+}
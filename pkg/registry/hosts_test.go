@@ -0,0 +1,40 @@
+package registry
+
+import (
+	"testing"
+)
+
+func TestEndpointsMirrors(t *testing.T) {
+	c, err := NewClientWithHosts(Hosts{
+		"docker.io": {
+			Mirrors:   []string{"mirror.local:5000", "other.local"},
+			PlainHTTP: true,
+			Insecure:  true,
+		},
+		"other.local": {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eps := c.endpoints("docker.io", true)
+	if len(eps) != 3 {
+		t.Fatalf("expected 3 endpoints, got %d", len(eps))
+	}
+
+	// Mirrors without configuration of their own inherit the one of the
+	// registry
+	if eps[0].host != "mirror.local:5000" || eps[0].scheme != "http" || eps[0].client != c.clients["docker.io"] {
+		t.Errorf("mirror not reached as configured for docker.io: %+v", eps[0])
+	}
+	if eps[1].host != "other.local" || eps[1].scheme != "https" || eps[1].client != c.client {
+		t.Errorf("mirror not reached as configured for itself: %+v", eps[1])
+	}
+	if eps[2].host != "registry-1.docker.io" || eps[2].scheme != "http" {
+		t.Errorf("unexpected registry endpoint %+v", eps[2])
+	}
+
+	if eps := c.endpoints("docker.io", false); len(eps) != 1 {
+		t.Errorf("expected only the registry for pushes, got %d endpoints", len(eps))
+	}
+}
@@ -0,0 +1,510 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/containerd/containerd/remotes/docker/auth"
+	remoteserrors "github.com/containerd/containerd/remotes/errors"
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/cli/config/configfile"
+	"github.com/docker/distribution/reference"
+	"github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+)
+
+const (
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	oauthClientID               = "terraform-provider-buildkit"
+)
+
+var (
+	manifestMediaTypes = []string{
+		ocispec.MediaTypeImageIndex,
+		ocispec.MediaTypeImageManifest,
+		mediaTypeDockerManifestList,
+		mediaTypeDockerManifest,
+	}
+)
+
+// Client implements the subset of the OCI distribution API the provider
+// needs for inspecting and cleaning up build results.
+// Credentials are taken from the Docker config, same as buildctl does.
+type Client struct {
+	client *http.Client
+	config *configfile.ConfigFile
+	hosts  Hosts
+	// Clients of hosts with custom TLS configuration
+	clients map[string]*http.Client
+
+	mu     sync.Mutex
+	tokens map[string]string
+}
+
+func NewClient() *Client {
+	return &Client{
+		client: http.DefaultClient,
+		config: config.LoadDefaultConfigFile(io.Discard),
+		tokens: map[string]string{},
+	}
+}
+
+// Reference is a parsed image reference split into the parts the
+// distribution API addresses.
+type Reference struct {
+	Named  reference.Named
+	Host   string
+	Repo   string
+	Tag    string
+	Digest digest.Digest
+}
+
+// ParseReference parses a (possibly familiar) image reference. References
+// without tag and digest default to tag latest.
+func ParseReference(s string) (*Reference, error) {
+	named, err := reference.ParseNormalizedNamed(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing reference %s failed", s)
+	}
+	r := &Reference{
+		Named: named,
+		Host:  reference.Domain(named),
+		Repo:  reference.Path(named),
+	}
+	if d, ok := named.(reference.Digested); ok {
+		r.Digest = d.Digest()
+	}
+	if t, ok := named.(reference.Tagged); ok {
+		r.Tag = t.Tag()
+	}
+	if r.Tag == "" && r.Digest == "" {
+		r.Tag = "latest"
+	}
+	return r, nil
+}
+
+// Object returns the tag or digest part used in API paths. A digest wins
+// over a tag since it is the more specific one.
+func (r *Reference) Object() string {
+	if r.Digest != "" {
+		return r.Digest.String()
+	}
+	return r.Tag
+}
+
+func (r *Reference) String() string {
+	return r.Named.String()
+}
+
+// Resolve fetches the descriptor of the manifest referenced by ref from the
+// registry itself.
+func (c *Client) Resolve(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+	desc, _, err := c.fetchManifest(ctx, ref, false)
+	return desc, err
+}
+
+// ResolveMirrored is Resolve trying the mirrors of the registry first, the
+// way BuildKit pulls. Mirrors may lag behind, so it only suits resolving
+// images a build pulls, never checking what was pushed.
+func (c *Client) ResolveMirrored(ctx context.Context, ref string) (ocispec.Descriptor, error) {
+	desc, _, err := c.fetchManifest(ctx, ref, true)
+	return desc, err
+}
+
+// FetchManifest fetches the raw manifest (or index) referenced by ref from
+// the registry itself.
+func (c *Client) FetchManifest(ctx context.Context, ref string) (ocispec.Descriptor, []byte, error) {
+	return c.fetchManifest(ctx, ref, false)
+}
+
+func (c *Client) fetchManifest(ctx context.Context, ref string, mirrored bool) (ocispec.Descriptor, []byte, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	resp, err := c.do(ctx, r, &request{
+		method: http.MethodGet,
+		path:   "manifests/" + r.Object(),
+		header: http.Header{
+			"Accept": []string{strings.Join(manifestMediaTypes, ", ")},
+		},
+		actions:  "pull",
+		mirrored: mirrored,
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	dt, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, errors.Wrapf(err, "reading manifest of %s failed", ref)
+	}
+	desc := ocispec.Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    digest.FromBytes(dt),
+		Size:      int64(len(dt)),
+	}
+	if r.Digest != "" && r.Digest != desc.Digest {
+		return ocispec.Descriptor{}, nil, errors.Errorf("manifest of %s does not match digest %s", ref, desc.Digest)
+	}
+	return desc, dt, nil
+}
+
+// DeleteTag removes the tag of ref from the registry while leaving the
+// manifest untouched. Not all registries implement deleting by tag.
+func (c *Client) DeleteTag(ctx context.Context, ref string) error {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	if r.Tag == "" {
+		return errors.Errorf("reference %s has no tag", ref)
+	}
+	return c.deleteManifest(ctx, r, r.Tag)
+}
+
+// DeleteManifest removes the manifest referenced by ref. This implicitly
+// removes all tags pointing to it.
+func (c *Client) DeleteManifest(ctx context.Context, ref string) error {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return err
+	}
+	dgst := r.Digest
+	if dgst == "" {
+		desc, err := c.Resolve(ctx, ref)
+		if err != nil {
+			return err
+		}
+		dgst = desc.Digest
+	}
+	return c.deleteManifest(ctx, r, dgst.String())
+}
+
+// PushBlob uploads dt to the repository of ref, unless the blob exists
+// already.
+func (c *Client) PushBlob(ctx context.Context, ref string, mediaType string, dt []byte) (ocispec.Descriptor, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(dt),
+		Size:      int64(len(dt)),
+	}
+
+	resp, err := c.do(ctx, r, &request{
+		method:  http.MethodHead,
+		path:    "blobs/" + desc.Digest.String(),
+		actions: "pull,push",
+	})
+	if err == nil {
+		resp.Body.Close()
+		return desc, nil
+	}
+	if !IsNotFound(err) {
+		return ocispec.Descriptor{}, err
+	}
+
+	resp, err = c.do(ctx, r, &request{
+		method:  http.MethodPost,
+		path:    "blobs/uploads/",
+		actions: "pull,push",
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "starting upload to %s failed", ref)
+	}
+	q := location.Query()
+	q.Set("digest", desc.Digest.String())
+	location.RawQuery = q.Encode()
+
+	resp, err = c.do(ctx, r, &request{
+		method: http.MethodPut,
+		url:    location.String(),
+		header: http.Header{
+			"Content-Type": []string{"application/octet-stream"},
+		},
+		body:    dt,
+		actions: "pull,push",
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	resp.Body.Close()
+	return desc, nil
+}
+
+// PushManifest uploads the manifest dt to ref, which is either tagged or
+// digested.
+func (c *Client) PushManifest(ctx context.Context, ref string, mediaType string, dt []byte) (ocispec.Descriptor, error) {
+	r, err := ParseReference(ref)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(dt),
+		Size:      int64(len(dt)),
+	}
+	if r.Digest != "" && r.Digest != desc.Digest {
+		return ocispec.Descriptor{}, errors.Errorf("manifest does not match digest of %s", ref)
+	}
+
+	resp, err := c.do(ctx, r, &request{
+		method: http.MethodPut,
+		path:   "manifests/" + r.Object(),
+		header: http.Header{
+			"Content-Type": []string{mediaType},
+		},
+		body:    dt,
+		actions: "pull,push",
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	resp.Body.Close()
+	return desc, nil
+}
+
+// AppendArtifact adds dt as layer to the artifact at ref, as cosign does for
+// further signatures and attestations. The artifact is created with an empty
+// config if it does not exist yet.
+func (c *Client) AppendArtifact(ctx context.Context, ref string, mediaType string, dt []byte, annotations map[string]string) (ocispec.Descriptor, error) {
+	desc, existing, err := c.FetchManifest(ctx, ref)
+	if IsNotFound(err) {
+		return c.pushArtifact(ctx, ref, nil, mediaType, dt, annotations)
+	}
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+
+	var m ocispec.Manifest
+	if err := json.Unmarshal(existing, &m); err != nil {
+		return ocispec.Descriptor{}, errors.Wrapf(err, "parsing manifest of %s failed", ref)
+	}
+	dgst := digest.FromBytes(dt)
+	for _, l := range m.Layers {
+		if l.Digest == dgst {
+			return desc, nil
+		}
+	}
+	return c.pushArtifact(ctx, ref, m.Layers, mediaType, dt, annotations)
+}
+
+func (c *Client) pushArtifact(ctx context.Context, ref string, layers []ocispec.Descriptor, mediaType string, dt []byte, annotations map[string]string) (ocispec.Descriptor, error) {
+	config, err := c.PushBlob(ctx, ref, ocispec.MediaTypeImageConfig, []byte("{}"))
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	layer, err := c.PushBlob(ctx, ref, mediaType, dt)
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	layer.Annotations = annotations
+
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{
+			SchemaVersion: 2,
+		},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    append(layers, layer),
+	})
+	if err != nil {
+		return ocispec.Descriptor{}, err
+	}
+	return c.PushManifest(ctx, ref, ocispec.MediaTypeImageManifest, manifest)
+}
+
+func (c *Client) deleteManifest(ctx context.Context, r *Reference, object string) error {
+	resp, err := c.do(ctx, r, &request{
+		method:  http.MethodDelete,
+		path:    "manifests/" + object,
+		actions: "delete",
+	})
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+type request struct {
+	method string
+	path   string
+	// Absolute URL, e.g. of an upload location, overriding path
+	url     string
+	header  http.Header
+	body    []byte
+	actions string
+	// Try mirrors of the registry first
+	mirrored bool
+}
+
+// do sends req to the repository of r. Mirrored requests are tried on
+// mirrors first. Authentication challenges are answered once, with the
+// resulting token cached per repository scope.
+func (c *Client) do(ctx context.Context, r *Reference, req *request) (*http.Response, error) {
+	var err error
+	for _, ep := range c.endpoints(r.Host, req.mirrored) {
+		var resp *http.Response
+		resp, err = c.doEndpoint(ctx, ep, r, req)
+		if err == nil {
+			return resp, nil
+		}
+	}
+	return nil, err
+}
+
+func (c *Client) doEndpoint(ctx context.Context, ep endpoint, r *Reference, req *request) (*http.Response, error) {
+	url := fmt.Sprintf("%s://%s/v2/%s/%s", ep.scheme, ep.host, r.Repo, req.path)
+	if req.url != "" {
+		url = req.url
+	}
+	scope := fmt.Sprintf("repository:%s:%s", r.Repo, req.actions)
+
+	resp, err := c.send(ctx, ep.client, req, url, c.authorization(ep.host, scope))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		authorization, err := c.authorize(ctx, ep, scope, resp)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp, err = c.send(ctx, ep.client, req, url, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		return nil, remoteserrors.NewUnexpectedStatusErr(resp)
+	}
+	return resp, nil
+}
+
+func (c *Client) send(ctx context.Context, client *http.Client, req *request, url string, authorization string) (*http.Response, error) {
+	hreq, err := http.NewRequestWithContext(ctx, req.method, url, bytes.NewReader(req.body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.header {
+		hreq.Header[k] = v
+	}
+	if authorization != "" {
+		hreq.Header.Set("Authorization", authorization)
+	}
+	resp, err := client.Do(hreq)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s %s failed", req.method, url)
+	}
+	return resp, nil
+}
+
+func (c *Client) authorization(host, scope string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tokens[host+"/"+scope]
+}
+
+func (c *Client) authorize(ctx context.Context, ep endpoint, scope string, resp *http.Response) (string, error) {
+	host := ep.host
+	username, secret, err := c.credentials(ep.domain)
+	if err != nil {
+		return "", err
+	}
+
+	var authorization string
+	for _, challenge := range auth.ParseAuthHeader(resp.Header) {
+		switch challenge.Scheme {
+		case auth.BearerAuth:
+			to, err := auth.GenerateTokenOptions(ctx, host, username, secret, challenge)
+			if err != nil {
+				return "", err
+			}
+			to.Scopes = []string{scope}
+			token, err := c.fetchToken(ctx, ep.client, to)
+			if err != nil {
+				return "", errors.Wrapf(err, "fetching token for %s failed", host)
+			}
+			authorization = "Bearer " + token
+		case auth.BasicAuth:
+			if username == "" && secret == "" {
+				continue
+			}
+			hreq := &http.Request{Header: http.Header{}}
+			hreq.SetBasicAuth(username, secret)
+			authorization = hreq.Header.Get("Authorization")
+		default:
+			continue
+		}
+		break
+	}
+	if authorization == "" {
+		return "", errors.Errorf("no supported authentication challenge from %s", host)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tokens[host+"/"+scope] = authorization
+	return authorization, nil
+}
+
+func (c *Client) fetchToken(ctx context.Context, client *http.Client, to auth.TokenOptions) (string, error) {
+	if to.Secret != "" {
+		resp, err := auth.FetchTokenWithOAuth(ctx, client, nil, oauthClientID, to)
+		if err == nil {
+			return resp.AccessToken, nil
+		}
+		// Registries without OAuth support still accept basic
+		// credentials on the token endpoint
+		var unexpected remoteserrors.ErrUnexpectedStatus
+		if !errors.As(err, &unexpected) || (unexpected.StatusCode != http.StatusNotFound && unexpected.StatusCode != http.StatusMethodNotAllowed) {
+			return "", err
+		}
+	}
+	resp, err := auth.FetchToken(ctx, client, nil, to)
+	if err != nil {
+		return "", err
+	}
+	return resp.Token, nil
+}
+
+func (c *Client) credentials(domain string) (string, string, error) {
+	if domain == "docker.io" {
+		domain = "https://index.docker.io/v1/"
+	}
+	ac, err := c.config.GetAuthConfig(domain)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "reading credentials for %s failed", domain)
+	}
+	if ac.IdentityToken != "" {
+		return "", ac.IdentityToken, nil
+	}
+	return ac.Username, ac.Password, nil
+}
+
+// IsNotFound reports whether err was caused by the registry not knowing
+// the requested object.
+func IsNotFound(err error) bool {
+	var unexpected remoteserrors.ErrUnexpectedStatus
+	return errors.As(err, &unexpected) && unexpected.StatusCode == http.StatusNotFound
+}
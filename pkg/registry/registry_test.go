@@ -0,0 +1,120 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// testServer serves a fixed manifest for every tag and records requests.
+type testServer struct {
+	manifest []byte
+	// Whether any blob exists
+	blobs bool
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, req.Method+" "+req.URL.Path)
+	s.mu.Unlock()
+
+	switch {
+	case strings.Contains(req.URL.Path, "/manifests/") && req.Method == http.MethodGet:
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+		w.Write(s.manifest)
+	case strings.Contains(req.URL.Path, "/blobs/uploads/"):
+		w.Header().Set("Location", req.URL.Path+"upload")
+		if req.Method == http.MethodPut {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	case strings.Contains(req.URL.Path, "/blobs/"):
+		if !s.blobs {
+			http.NotFound(w, req)
+		}
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (s *testServer) requested(prefix string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMirrorsOnlyResolvePulls(t *testing.T) {
+	upstream := &testServer{manifest: []byte(`{"schemaVersion":2,"annotations":{"tag":"current"}}`)}
+	mirror := &testServer{
+		manifest: []byte(`{"schemaVersion":2,"annotations":{"tag":"stale"}}`),
+		blobs:    true,
+	}
+	upstreamSrv := httptest.NewServer(upstream)
+	defer upstreamSrv.Close()
+	mirrorSrv := httptest.NewServer(mirror)
+	defer mirrorSrv.Close()
+
+	host := strings.TrimPrefix(upstreamSrv.URL, "http://")
+	mirrorHost := strings.TrimPrefix(mirrorSrv.URL, "http://")
+	c, err := NewClientWithHosts(Hosts{
+		host: {
+			Mirrors:   []string{mirrorHost},
+			PlainHTTP: true,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	ref := host + "/test/app:latest"
+	desc, err := c.ResolveMirrored(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != digest.FromBytes(mirror.manifest) {
+		t.Errorf("expected pulls to resolve via the mirror, got %s", desc.Digest)
+	}
+
+	// Checking pushed tags must not be fooled by a stale mirror
+	desc, err = c.Resolve(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != digest.FromBytes(upstream.manifest) {
+		t.Errorf("expected the tag of the registry, got %s", desc.Digest)
+	}
+	_, dt, err := c.FetchManifest(ctx, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(dt) != string(upstream.manifest) {
+		t.Errorf("expected the manifest of the registry, got %s", dt)
+	}
+
+	// Blobs existing on the mirror only are uploaded nevertheless
+	if _, err := c.PushBlob(ctx, ref, ocispec.MediaTypeImageLayer, []byte("layer")); err != nil {
+		t.Fatal(err)
+	}
+	if !upstream.requested(http.MethodPut + " /v2/test/app/blobs/uploads/") {
+		t.Error("blob not uploaded to the registry")
+	}
+	if mirror.requested(http.MethodHead) {
+		t.Error("existence of the blob checked on the mirror")
+	}
+}
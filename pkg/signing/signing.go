@@ -0,0 +1,92 @@
+// Package signing creates cosign compatible signatures of image manifests.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// Media type of the layer holding the signed payload
+	SimpleSigningMediaType = "application/vnd.dev.cosign.simplesigning.v1+json"
+	// Annotation of the layer holding the base64 encoded signature
+	SignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+	signatureType = "cosign container image signature"
+)
+
+// ParsePrivateKey parses a PEM encoded, unencrypted ECDSA or Ed25519
+// private key.
+func ParsePrivateKey(dt []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(dt)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		switch k := key.(type) {
+		case *ecdsa.PrivateKey:
+			return k, nil
+		case ed25519.PrivateKey:
+			return k, nil
+		}
+		return nil, errors.Errorf("unsupported private key type %T, has to be ECDSA or Ed25519", key)
+	}
+	return nil, errors.Errorf("unsupported PEM block %s, has to be an unencrypted ECDSA or Ed25519 key", block.Type)
+}
+
+// Sign signs dt with key. ECDSA keys sign the SHA-256 digest of dt, Ed25519
+// keys sign dt itself.
+func Sign(key crypto.Signer, dt []byte) ([]byte, error) {
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		return key.Sign(rand.Reader, dt, crypto.Hash(0))
+	}
+	h := sha256.Sum256(dt)
+	return key.Sign(rand.Reader, h[:], crypto.SHA256)
+}
+
+// Payload creates the simple signing payload of the manifest dgst of
+// repository repo.
+func Payload(repo string, dgst digest.Digest) ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"critical": map[string]interface{}{
+			"identity": map[string]string{
+				"docker-reference": repo,
+			},
+			"image": map[string]string{
+				"docker-manifest-digest": dgst.String(),
+			},
+			"type": signatureType,
+		},
+		"optional": nil,
+	})
+}
+
+// SignatureTag is the tag cosign stores signatures of the manifest dgst
+// under.
+func SignatureTag(dgst digest.Digest) string {
+	return fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded())
+}
+
+// PAE is the pre-authentication encoding of DSSE, which is signed instead of
+// the payload of an envelope.
+func PAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}